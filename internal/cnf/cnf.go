@@ -0,0 +1,255 @@
+// Package cnf encodes a csp.Problem as a boolean formula in
+// conjunctive normal form, so that it can be handed to an external
+// SAT solver in DIMACS format, and maps the solver's model back onto
+// the Problem.
+package cnf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+// An Encoding chooses how the domain of each decision becomes
+// boolean variables.
+type Encoding int
+
+const (
+	// OneHot gives each value of a decision its own variable, true
+	// when the decision takes that value. Decision i's value v is
+	// variable 2 + i*ValueSize + v.
+	OneHot Encoding = iota
+	// Order gives each value v above zero a variable that is true
+	// when the decision's value is at least v, which suits
+	// constraints that compare values.
+	Order
+)
+
+// A CNFEncoder is a csp.ConstraintChecker that can describe itself
+// as clauses. Encode fails on any group whose constraint is not one.
+type CNFEncoder interface {
+	EncodeCNF(f *Formula, all []*csp.Decision)
+}
+
+// A Formula is a conjunction of clauses, each a disjunction of
+// literals. Literal n is variable n, and -n its negation; variables
+// are numbered from 1.
+type Formula struct {
+	NumVars int
+	Clauses [][]int
+
+	p        *csp.Problem
+	encoding Encoding
+	// top is a variable that is always true.
+	top     int
+	is      map[key]int
+	atLeast map[key]int
+}
+
+type key struct {
+	d, v int
+}
+
+// Encode returns a Formula whose models correspond to the solutions
+// of p from its current state.
+func Encode(p *csp.Problem, encoding Encoding) (*Formula, error) {
+	f := &Formula{p: p, encoding: encoding, is: map[key]int{}, atLeast: map[key]int{}}
+	f.top = f.NewVar()
+	f.Add(f.top)
+	for i := 0; i < p.Size(); i++ {
+		f.encodeDomain(p.Get(i))
+	}
+	for i, g := range p.Groups() {
+		c, ok := g.Constraint().(CNFEncoder)
+		if !ok {
+			name := g.Name()
+			if name == "" {
+				name = fmt.Sprintf("group %d", i)
+			}
+			return nil, fmt.Errorf("cnf: %s: %T has no CNF encoding", name, g.Constraint())
+		}
+		c.EncodeCNF(f, g.Decisions())
+	}
+	return f, nil
+}
+
+func (f *Formula) encodeDomain(d *csp.Decision) {
+	size := f.p.ValueSize()
+	if f.encoding == OneHot {
+		var possible []int
+		for v := 0; v < size; v++ {
+			f.is[key{d.Index(), v}] = f.NewVar()
+			if d.Possible(v) {
+				possible = append(possible, f.Is(d, v))
+			} else {
+				f.Add(-f.Is(d, v))
+			}
+		}
+		f.Add(possible...)
+		f.AtMostOne(possible)
+		return
+	}
+	for v := 1; v < size; v++ {
+		f.atLeast[key{d.Index(), v}] = f.NewVar()
+		if v > 1 {
+			f.Add(-f.AtLeast(d, v), f.AtLeast(d, v-1))
+		}
+	}
+	for v := 0; v < size; v++ {
+		if !d.Possible(v) {
+			f.Add(-f.AtLeast(d, v), f.AtLeast(d, v+1))
+		}
+	}
+}
+
+// Problem returns the encoded Problem.
+func (f *Formula) Problem() *csp.Problem {
+	return f.p
+}
+
+// ValueSize returns the number of values of the encoded Problem.
+func (f *Formula) ValueSize() int {
+	return f.p.ValueSize()
+}
+
+// NewVar returns a new variable, for constraints that need auxiliary
+// variables.
+func (f *Formula) NewVar() int {
+	f.NumVars++
+	return f.NumVars
+}
+
+// Add adds a clause.
+func (f *Formula) Add(clause ...int) {
+	f.Clauses = append(f.Clauses, append([]int(nil), clause...))
+}
+
+// Is returns a literal that is true when d takes the value v.
+func (f *Formula) Is(d *csp.Decision, v int) int {
+	if v < 0 || v >= f.p.ValueSize() {
+		return -f.top
+	}
+	k := key{d.Index(), v}
+	if lit, ok := f.is[k]; ok {
+		return lit
+	}
+	// Under the order encoding, d is v when it is at least v but
+	// not at least v+1.
+	lit := f.NewVar()
+	ge, gt := f.AtLeast(d, v), f.AtLeast(d, v+1)
+	f.Add(-lit, ge)
+	f.Add(-lit, -gt)
+	f.Add(lit, -ge, gt)
+	f.is[k] = lit
+	return lit
+}
+
+// AtLeast returns a literal that is true when d's value is at least
+// v.
+func (f *Formula) AtLeast(d *csp.Decision, v int) int {
+	if v <= 0 {
+		return f.top
+	}
+	if v >= f.p.ValueSize() {
+		return -f.top
+	}
+	k := key{d.Index(), v}
+	if lit, ok := f.atLeast[k]; ok {
+		return lit
+	}
+	// Under the one-hot encoding, d is at least v when it is one of
+	// the values from v up.
+	lit := f.NewVar()
+	clause := []int{-lit}
+	for u := v; u < f.p.ValueSize(); u++ {
+		clause = append(clause, f.Is(d, u))
+		f.Add(lit, -f.Is(d, u))
+	}
+	f.Add(clause...)
+	f.atLeast[k] = lit
+	return lit
+}
+
+// AtMostOne requires at most one of lits to be true.
+func (f *Formula) AtMostOne(lits []int) {
+	if len(lits) <= 6 {
+		for i, a := range lits {
+			for _, b := range lits[i+1:] {
+				f.Add(-a, -b)
+			}
+		}
+		return
+	}
+	f.AtMostK(lits, 1)
+}
+
+// AtMostK requires at most k of lits to be true, using Sinz's
+// sequential counter.
+func (f *Formula) AtMostK(lits []int, k int) {
+	n := len(lits)
+	if k >= n {
+		return
+	}
+	if k <= 0 {
+		for _, x := range lits {
+			f.Add(-x)
+		}
+		return
+	}
+	// s[i][j] is true when at least j+1 of lits[:i+1] are true.
+	s := make([][]int, n-1)
+	for i := range s {
+		s[i] = make([]int, k)
+		for j := range s[i] {
+			s[i][j] = f.NewVar()
+		}
+	}
+	f.Add(-lits[0], s[0][0])
+	for j := 1; j < k; j++ {
+		f.Add(-s[0][j])
+	}
+	for i := 1; i < n-1; i++ {
+		f.Add(-lits[i], s[i][0])
+		f.Add(-s[i-1][0], s[i][0])
+		for j := 1; j < k; j++ {
+			f.Add(-lits[i], -s[i-1][j-1], s[i][j])
+			f.Add(-s[i-1][j], s[i][j])
+		}
+		f.Add(-lits[i], -s[i-1][k-1])
+	}
+	f.Add(-lits[n-1], -s[n-2][k-1])
+}
+
+// AtLeastK requires at least k of lits to be true.
+func (f *Formula) AtLeastK(lits []int, k int) {
+	if k > len(lits) {
+		f.Add()
+		return
+	}
+	var negated []int
+	for _, x := range lits {
+		negated = append(negated, -x)
+	}
+	f.AtMostK(negated, len(lits)-k)
+}
+
+// ExactlyK requires exactly k of lits to be true.
+func (f *Formula) ExactlyK(lits []int, k int) {
+	f.AtMostK(lits, k)
+	f.AtLeastK(lits, k)
+}
+
+// Write writes the formula in DIMACS CNF format.
+func (f *Formula) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "p cnf %d %d\n", f.NumVars, len(f.Clauses))
+	for _, clause := range f.Clauses {
+		for _, lit := range clause {
+			fmt.Fprintf(bw, "%d ", lit)
+		}
+		fmt.Fprintln(bw, "0")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,314 @@
+package cnf_test
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/offpath/puzzleutils/internal/cnf"
+	"github.com/offpath/puzzleutils/internal/constraints"
+	"github.com/offpath/puzzleutils/internal/csp"
+	"github.com/offpath/puzzleutils/internal/decide"
+	"github.com/offpath/puzzleutils/internal/trie"
+)
+
+// readDIMACS parses the output of Formula.Write.
+func readDIMACS(t *testing.T, s string) [][]int {
+	var result [][]int
+	var clause []int
+	for _, line := range strings.Split(s, "\n") {
+		if line == "" || strings.HasPrefix(line, "p ") {
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			lit, err := strconv.Atoi(field)
+			if err != nil {
+				t.Fatalf("bad literal %q", field)
+			}
+			if lit == 0 {
+				result = append(result, clause)
+				clause = nil
+			} else {
+				clause = append(clause, lit)
+			}
+		}
+	}
+	return result
+}
+
+// sat is a minimal DPLL solver, returning a model in the competition
+// output format, or "" if there is none.
+func sat(clauses [][]int, assigned map[int]bool) string {
+	for changed := true; changed; {
+		changed = false
+		for _, clause := range clauses {
+			open, unit := 0, 0
+			satisfied := false
+			for _, lit := range clause {
+				v, ok := assigned[abs(lit)]
+				if !ok {
+					open++
+					unit = lit
+				} else if v == (lit > 0) {
+					satisfied = true
+					break
+				}
+			}
+			if satisfied {
+				continue
+			}
+			if open == 0 {
+				return ""
+			}
+			if open == 1 {
+				assigned[abs(unit)] = unit > 0
+				changed = true
+			}
+		}
+	}
+	for _, clause := range clauses {
+		for _, lit := range clause {
+			if _, ok := assigned[abs(lit)]; !ok {
+				for _, v := range []bool{true, false} {
+					next := map[int]bool{abs(lit): v}
+					for k, v := range assigned {
+						next[k] = v
+					}
+					if result := sat(clauses, next); result != "" {
+						return result
+					}
+				}
+				return ""
+			}
+		}
+	}
+	var b strings.Builder
+	b.WriteString("c found by the test solver\ns SATISFIABLE\nv")
+	for v, value := range assigned {
+		if value {
+			fmt.Fprintf(&b, " %d", v)
+		} else {
+			fmt.Fprintf(&b, " %d", -v)
+		}
+	}
+	b.WriteString(" 0\n")
+	return b.String()
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// dimacs returns the output of encoding p.
+func dimacs(t *testing.T, p *csp.Problem, encoding cnf.Encoding) string {
+	f, err := cnf.Encode(p, encoding)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var b bytes.Buffer
+	if err := f.Write(&b); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return b.String()
+}
+
+// countModels counts the solutions of the problem built by newProblem
+// through its CNF encoding, blocking each solution once found.
+func countModels(t *testing.T, newProblem func() *csp.Problem, encoding cnf.Encoding) int {
+	f, err := cnf.Encode(newProblem(), encoding)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var blocked [][]int
+	for count := 0; ; count++ {
+		var b bytes.Buffer
+		if err := f.Write(&b); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		output := sat(append(readDIMACS(t, b.String()), blocked...), map[int]bool{})
+		if output == "" {
+			output = "s UNSATISFIABLE\n"
+		}
+		m, err := cnf.ReadModel(strings.NewReader(output))
+		if err == cnf.ErrUnsatisfiable {
+			return count
+		} else if err != nil {
+			t.Fatalf("read model: %v", err)
+		}
+		values, err := f.Decode(m)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		// Applying the model to a fresh copy checks it against the
+		// solver's own constraints.
+		check, err := cnf.Encode(newProblem(), encoding)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if err := check.Apply(m); err != nil {
+			t.Fatalf("model %v: %v", values, err)
+		}
+		var block []int
+		for i, v := range values {
+			block = append(block, -f.Is(f.Problem().Get(i), v))
+		}
+		blocked = append(blocked, block)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, tt := range []struct {
+		test       string
+		newProblem func() *csp.Problem
+	}{
+		{"unique", func() *csp.Problem {
+			p := csp.NewProblem(4, 5)
+			p.AddGroup([]int{0, 1, 2, 3}, constraints.Unique(false))
+			p.Set(0, 2)
+			return p
+		}},
+		{"unique covering", func() *csp.Problem {
+			p := csp.NewProblem(4, 4)
+			p.AddGroup([]int{0, 1, 2, 3}, constraints.UniqueCovering())
+			p.Get(1).Restrict(0)
+			return p
+		}},
+		{"long unique covering", func() *csp.Problem {
+			p := csp.NewProblem(7, 7)
+			p.AddGroup([]int{0, 1, 2, 3, 4, 5, 6}, constraints.UniqueCovering())
+			for i := 0; i < 5; i++ {
+				p.Set(i, i)
+			}
+			return p
+		}},
+		{"equal and set", func() *csp.Problem {
+			p := csp.NewProblem(3, 5)
+			p.AddGroup([]int{0, 1}, constraints.Equal())
+			p.AddGroup([]int{1, 2}, constraints.Set(map[int]bool{1: true, 3: true}))
+			return p
+		}},
+		{"set count", func() *csp.Problem {
+			p := csp.NewProblem(5, 3)
+			p.AddGroup([]int{0, 1, 2, 3, 4}, constraints.SetCount(map[int]int{0: 2, 2: 3}, true))
+			return p
+		}},
+		{"set count impossible", func() *csp.Problem {
+			p := csp.NewProblem(5, 3)
+			p.AddGroup([]int{0, 1, 2, 3, 4}, constraints.SetCount(map[int]int{0: 2, 2: 4}, true))
+			return p
+		}},
+		{"less than", func() *csp.Problem {
+			p := csp.NewProblem(3, 4)
+			p.AddGroup([]int{0, 1, 2}, constraints.LessThan(nil))
+			return p
+		}},
+		{"black dot", func() *csp.Problem {
+			p := csp.NewProblem(2, 5)
+			p.AddGroup([]int{0, 1}, constraints.Ratio(2, []int{1, 2, 3, 4, 5}))
+			return p
+		}},
+		{"table", func() *csp.Problem {
+			p := csp.NewProblem(3, 3)
+			p.AddGroup([]int{0, 1, 2}, constraints.Table([][]int{{0, 1, 2}, {1, 2, 0}, {2, 2, 2}}))
+			p.Get(2).Restrict(2)
+			return p
+		}},
+		{"forbidden", func() *csp.Problem {
+			p := csp.NewProblem(2, 3)
+			p.AddGroup([]int{0, 1}, constraints.Forbidden([][]int{{0, 0}, {1, 1}, {0, 2}}))
+			return p
+		}},
+		{"regular", func() *csp.Problem {
+			p := csp.NewProblem(4, 3)
+			c, err := constraints.RegularExpression("a*(bc|cb)a?", []string{"a", "b", "c"})
+			if err != nil {
+				panic(err)
+			}
+			p.AddGroup([]int{0, 1, 2, 3}, c)
+			return p
+		}},
+		{"killer cage", func() *csp.Problem {
+			p := csp.NewProblem(3, 9)
+			p.AddGroup([]int{0, 1, 2}, constraints.Sum(10, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, true))
+			return p
+		}},
+		{"sum", func() *csp.Problem {
+			p := csp.NewProblem(3, 4)
+			p.AddGroup([]int{0, 1, 2}, constraints.Sum(5, nil, false))
+			p.Get(1).Restrict(1)
+			return p
+		}},
+		{"sum impossible", func() *csp.Problem {
+			p := csp.NewProblem(2, 3)
+			p.AddGroup([]int{0, 1}, constraints.Sum(5, nil, false))
+			return p
+		}},
+		{"kenken cage", func() *csp.Problem {
+			p := csp.NewProblem(3, 4)
+			p.AddGroup([]int{0, 1, 2}, constraints.Cage(constraints.Multiply, 12, []int{1, 2, 3, 4}))
+			return p
+		}},
+		{"cage add", func() *csp.Problem {
+			p := csp.NewProblem(3, 4)
+			p.AddGroup([]int{0, 1, 2}, constraints.Cage(constraints.Add, 7, []int{1, 2, 3, 4}))
+			return p
+		}},
+		{"cage any", func() *csp.Problem {
+			p := csp.NewProblem(2, 6)
+			p.AddGroup([]int{0, 1}, constraints.Cage(constraints.AnyOp, 2, []int{1, 2, 3, 4, 5, 6}))
+			return p
+		}},
+		{"set count not covering", func() *csp.Problem {
+			p := csp.NewProblem(4, 3)
+			p.AddGroup([]int{0, 1, 2, 3}, constraints.SetCount(map[int]int{0: 1, 1: 2}, false))
+			return p
+		}},
+	} {
+		want := tt.newProblem().Count(csp.Settings{Decider: &decide.First{}})
+		for _, encoding := range []cnf.Encoding{cnf.OneHot, cnf.Order} {
+			if got := countModels(t, tt.newProblem, encoding); got != want {
+				t.Errorf("test: %s, encoding %d, got %d solutions, want %d", tt.test, encoding, got, want)
+			}
+			// The output is the same every time.
+			if a, b := dimacs(t, tt.newProblem(), encoding), dimacs(t, tt.newProblem(), encoding); a != b {
+				t.Errorf("test: %s, encoding %d, output differs between runs", tt.test, encoding)
+			}
+		}
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	p := csp.NewProblem(2, 26)
+	p.AddNamedGroup("word", []int{0, 1}, constraints.ValidWord(trie.New(), nil))
+	if _, err := cnf.Encode(p, cnf.OneHot); err == nil || !strings.Contains(err.Error(), "word") {
+		t.Errorf("got error %v, want one naming the group", err)
+	}
+}
+
+func TestReadModel(t *testing.T) {
+	for _, tt := range []struct {
+		test   string
+		output string
+		want   cnf.Model
+		err    bool
+	}{
+		{"competition", "c comment\ns SATISFIABLE\nv 1 -2\nv 3 0\n", cnf.Model{1: true, 2: false, 3: true}, false},
+		{"minisat", "SAT\n-1 2 0\n", cnf.Model{1: false, 2: true}, false},
+		{"unsat", "UNSAT\n", nil, true},
+		{"unknown", "s UNKNOWN\n", nil, true},
+		{"empty", "", nil, true},
+	} {
+		got, err := cnf.ReadModel(strings.NewReader(tt.output))
+		if (err != nil) != tt.err {
+			t.Errorf("test: %s, got error %v", tt.test, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
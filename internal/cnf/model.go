@@ -0,0 +1,120 @@
+package cnf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+// ErrUnsatisfiable is returned by ReadModel when the solver reports
+// that the formula has no model.
+var ErrUnsatisfiable = errors.New("cnf: formula is unsatisfiable")
+
+// A Model holds the value a SAT solver gave each variable.
+type Model map[int]bool
+
+// ReadModel reads a SAT solver's output. It accepts both the
+// competition format, with "s SATISFIABLE" followed by "v" lines of
+// literals, and the MiniSat result file format, with "SAT" followed
+// by a line of literals.
+func ReadModel(r io.Reader) (Model, error) {
+	m := Model{}
+	status := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "c" {
+			continue
+		}
+		switch fields[0] {
+		case "s":
+			status = strings.Join(fields[1:], " ")
+			continue
+		case "SAT", "UNSAT", "INDET":
+			status = fields[0]
+			continue
+		case "v":
+			fields = fields[1:]
+		}
+		for _, field := range fields {
+			lit, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("cnf: bad literal %q in model", field)
+			}
+			if lit > 0 {
+				m[lit] = true
+			} else if lit < 0 {
+				m[-lit] = false
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	switch status {
+	case "SATISFIABLE", "SAT":
+		return m, nil
+	case "UNSATISFIABLE", "UNSAT":
+		return nil, ErrUnsatisfiable
+	case "":
+		return nil, errors.New("cnf: no result in solver output")
+	}
+	return nil, fmt.Errorf("cnf: solver gave result %q", status)
+}
+
+// Decode returns the value of each decision under m.
+func (f *Formula) Decode(m Model) ([]int, error) {
+	var result []int
+	for i := 0; i < f.p.Size(); i++ {
+		d := f.p.Get(i)
+		value := -1
+		if f.encoding == OneHot {
+			for v := 0; v < f.p.ValueSize(); v++ {
+				if !m[f.Is(d, v)] {
+					continue
+				}
+				if value >= 0 {
+					return nil, fmt.Errorf("cnf: model gives decision %d both %d and %d", i, value, v)
+				}
+				value = v
+			}
+		} else {
+			value = 0
+			for value+1 < f.p.ValueSize() && m[f.AtLeast(d, value+1)] {
+				value++
+			}
+		}
+		if value < 0 {
+			return nil, fmt.Errorf("cnf: model gives decision %d no value", i)
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// Apply sets each decision of the encoded Problem to its value under
+// m, and checks the result against the Problem's constraints. On
+// error the Problem may be left partly set.
+func (f *Formula) Apply(m Model) error {
+	values, err := f.Decode(m)
+	if err != nil {
+		return err
+	}
+	for i, v := range values {
+		d := f.p.Get(i)
+		if !d.Possible(v) {
+			return fmt.Errorf("cnf: model gives decision %d the impossible value %d", i, v)
+		}
+		d.RestrictTo(v)
+	}
+	if _, ok := f.p.Propagate(csp.Settings{}); !ok {
+		return errors.New("cnf: model breaks a constraint")
+	}
+	return nil
+}
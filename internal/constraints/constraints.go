@@ -1,10 +1,104 @@
 package constraints
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/offpath/puzzleutils/internal/cnf"
 	"github.com/offpath/puzzleutils/internal/csp"
 	"github.com/offpath/puzzleutils/internal/trie"
 )
 
+func init() {
+	csp.RegisterConstraint("unique", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c uniqueParams
+		err := json.Unmarshal(params, &c)
+		return Unique(c.Covering), err
+	})
+	csp.RegisterConstraint("equal", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		return Equal(), nil
+	})
+	csp.RegisterConstraint("set", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c setParams
+		err := json.Unmarshal(params, &c)
+		s := map[int]bool{}
+		for _, v := range c.Values {
+			s[v] = true
+		}
+		return Set(s), err
+	})
+	csp.RegisterConstraint("setCount", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c setCountParams
+		err := json.Unmarshal(params, &c)
+		return SetCount(c.Counts, c.Covering), err
+	})
+	csp.RegisterConstraint("sum", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c sumParams
+		err := json.Unmarshal(params, &c)
+		return Sum(c.Target, c.Weights, c.Unique), err
+	})
+	csp.RegisterConstraint("cage", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c cageParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		if c.Op < Add || c.Op > AnyOp {
+			return nil, fmt.Errorf("constraints: unknown cage operator %d", int(c.Op))
+		}
+		return Cage(c.Op, c.Result, c.Weights), nil
+	})
+	csp.RegisterConstraint("pairwise", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c pairwiseParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		// The weights are checked against the value size by
+		// CheckGroup, once the group is known.
+		switch c.Relation {
+		case lessThan:
+			return LessThan(c.Weights), nil
+		case differAtLeast:
+			return DifferAtLeast(c.K, c.Weights), nil
+		case differBy:
+			return DifferBy(c.K, c.Weights), nil
+		case ratio:
+			return Ratio(c.K, c.Weights), nil
+		}
+		return nil, fmt.Errorf("constraints: unknown pairwise relation %d", int(c.Relation))
+	})
+	csp.RegisterConstraint("table", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c tableParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		if err := checkTuples(c.Tuples); err != nil {
+			return nil, err
+		}
+		return Table(c.Tuples), nil
+	})
+	csp.RegisterConstraint("forbidden", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c tableParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		if err := checkTuples(c.Tuples); err != nil {
+			return nil, err
+		}
+		return Forbidden(c.Tuples), nil
+	})
+	csp.RegisterConstraint("regular", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var a DFA
+		if err := json.Unmarshal(params, &a); err != nil {
+			return nil, err
+		}
+		if err := a.check(); err != nil {
+			return nil, err
+		}
+		return Regular(a), nil
+	})
+}
+
 func Unique(isCovering bool) csp.ConstraintChecker {
 	return unique{isCovering}
 }
@@ -13,6 +107,13 @@ func UniqueCovering() csp.ConstraintChecker {
 	return Unique(true)
 }
 
+// IsUnique reports whether c was made by Unique, and if so whether it
+// is covering, for tools that reason about uniqueness directly.
+func IsUnique(c csp.ConstraintChecker) (isUnique, isCovering bool) {
+	u, ok := c.(unique)
+	return ok, ok && u.isCovering
+}
+
 func Equal() csp.ConstraintChecker {
 	return equal{}
 }
@@ -25,6 +126,85 @@ func SetCount(s map[int]int, isCovering bool) csp.ConstraintChecker {
 	return setCount{s, isCovering}
 }
 
+// Sum requires the weights of a group's values to add up to target,
+// where weights[v] is the weight of value v, or v itself if weights is
+// nil. Weights that are not nil must cover every value. If isUnique is
+// set the values must also differ, as in killer sudoku cages and
+// kakuro runs.
+func Sum(target int, weights []int, isUnique bool) csp.ConstraintChecker {
+	return sum{target, valueWeights(weights), isUnique}
+}
+
+// An Op is the arithmetic operator of a Cage.
+type Op int
+
+const (
+	Add Op = iota
+	// Subtract and Divide start from the largest value and subtract
+	// or divide by each of the others, which for the usual two cells
+	// is the difference and the quotient of the larger by the smaller.
+	Subtract
+	Multiply
+	Divide
+	// AnyOp is satisfied by any of the other operators, for cages
+	// that give only a result.
+	AnyOp
+)
+
+func (op Op) String() string {
+	switch op {
+	case Add:
+		return "+"
+	case Subtract:
+		return "-"
+	case Multiply:
+		return "*"
+	case Divide:
+		return "/"
+	case AnyOp:
+		return "?"
+	}
+	return fmt.Sprintf("Op(%d)", int(op))
+}
+
+// Cage requires op to combine the weights of a group's values into
+// result, as in KenKen, with weights as for Sum. An Add cage is a Sum.
+// Other cages find the possible values by trying every combination
+// left, which suits the small cages of such puzzles; beyond the
+// limit Sum searches to, Multiply cages are pruned by bounds and the
+// others wait until fewer combinations are left.
+func Cage(op Op, result int, weights []int) csp.ConstraintChecker {
+	return cage{op, result, valueWeights(weights)}
+}
+
+// LessThan requires the weights of a group's values to increase
+// strictly along the group, as across a futoshiki inequality or up a
+// sudoku thermometer. Weights are as for Sum.
+func LessThan(weights []int) csp.ConstraintChecker {
+	return pairwise{lessThan, 0, valueWeights(weights)}
+}
+
+// DifferAtLeast requires neighbouring values along a group to differ
+// in weight by at least k, as on a German whispers line.
+func DifferAtLeast(k int, weights []int) csp.ConstraintChecker {
+	return pairwise{differAtLeast, k, valueWeights(weights)}
+}
+
+// DifferBy requires neighbouring values along a group to differ in
+// weight by exactly k, as across a white Kropki dot with k = 1.
+func DifferBy(k int, weights []int) csp.ConstraintChecker {
+	return pairwise{differBy, k, valueWeights(weights)}
+}
+
+// Ratio requires one of each pair of neighbouring values along a group
+// to weigh k times the other, as across a black Kropki dot with k = 2.
+func Ratio(k int, weights []int) csp.ConstraintChecker {
+	return pairwise{ratio, k, valueWeights(weights)}
+}
+
+// ValidWord requires the values along a group to spell a word in t.
+// Problems using it cannot be saved as JSON, since t is not part of
+// the Problem.
 func ValidWord(t *trie.Trie, valueSet []string) csp.ConstraintChecker {
 	return validWord{t, valueSet}
 }
@@ -64,6 +244,27 @@ func (c unique) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c unique) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for v := 0; v < f.ValueSize(); v++ {
+		var lits []int
+		for _, d := range all {
+			lits = append(lits, f.Is(d, v))
+		}
+		f.AtMostOne(lits)
+		if c.isCovering && v < len(all) {
+			f.Add(lits...)
+		}
+	}
+}
+
+type uniqueParams struct {
+	Covering bool `json:"covering"`
+}
+
+func (c unique) MarshalConstraint() (string, interface{}) {
+	return "unique", uniqueParams{c.isCovering}
+}
+
 type equal struct{}
 
 func (c equal) Init(all []*csp.Decision, size int) {}
@@ -78,6 +279,19 @@ func (c equal) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c equal) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for _, d := range all[1:] {
+		for v := 0; v < f.ValueSize(); v++ {
+			f.Add(-f.Is(all[0], v), f.Is(d, v))
+			f.Add(f.Is(all[0], v), -f.Is(d, v))
+		}
+	}
+}
+
+func (c equal) MarshalConstraint() (string, interface{}) {
+	return "equal", nil
+}
+
 type set struct {
 	s map[int]bool
 }
@@ -91,6 +305,31 @@ func (c set) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c set) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for _, d := range all {
+		for v := 0; v < f.ValueSize(); v++ {
+			if !c.s[v] {
+				f.Add(-f.Is(d, v))
+			}
+		}
+	}
+}
+
+type setParams struct {
+	Values []int `json:"values"`
+}
+
+func (c set) MarshalConstraint() (string, interface{}) {
+	var values []int
+	for v, ok := range c.s {
+		if ok {
+			values = append(values, v)
+		}
+	}
+	sort.Ints(values)
+	return "set", setParams{values}
+}
+
 type setCount struct {
 	s          map[int]int
 	isCovering bool
@@ -132,6 +371,514 @@ func (c setCount) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c setCount) Cost() int {
+	return csp.CostLinear
+}
+
+func (c setCount) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for _, d := range all {
+		for v := 0; v < f.ValueSize(); v++ {
+			if _, ok := c.s[v]; !ok {
+				f.Add(-f.Is(d, v))
+			}
+		}
+	}
+	// Sorting the items keeps the output the same from run to run.
+	var items []int
+	for item := range c.s {
+		items = append(items, item)
+	}
+	sort.Ints(items)
+	for _, item := range items {
+		var lits []int
+		for _, d := range all {
+			lits = append(lits, f.Is(d, item))
+		}
+		if c.isCovering {
+			f.ExactlyK(lits, c.s[item])
+		} else {
+			f.AtMostK(lits, c.s[item])
+		}
+	}
+}
+
+type setCountParams struct {
+	Counts   map[int]int `json:"counts"`
+	Covering bool        `json:"covering"`
+}
+
+func (c setCount) MarshalConstraint() (string, interface{}) {
+	return "setCount", setCountParams{c.s, c.isCovering}
+}
+
+// valueWeights holds the weight of each value for the arithmetic
+// constraints, or is nil to weigh each value as itself.
+type valueWeights []int
+
+func (w valueWeights) weight(v int) int {
+	if w == nil {
+		return v
+	}
+	return w[v]
+}
+
+// bounds returns the least and greatest weights of values.
+func (w valueWeights) bounds(values []int) (int, int) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	least, most := w.weight(values[0]), w.weight(values[0])
+	for _, v := range values[1:] {
+		if x := w.weight(v); x < least {
+			least = x
+		} else if x > most {
+			most = x
+		}
+	}
+	return least, most
+}
+
+// check reports whether there is a weight for each of size values.
+func (w valueWeights) check(size int) error {
+	if w != nil && len(w) < size {
+		return fmt.Errorf("constraints: %d weights for %d values", len(w), size)
+	}
+	return nil
+}
+
+type sum struct {
+	target   int
+	weights  valueWeights
+	isUnique bool
+}
+
+// sumEnumerate is the most assignments, counted as the product of the
+// domain sizes, that sum will search to find exactly which values can
+// still make the target. Larger groups are pruned by bounds alone.
+const sumEnumerate = 1 << 16
+
+func (c sum) Init(all []*csp.Decision, size int) {}
+
+func (c sum) Apply(all, dirty []*csp.Decision) bool {
+	if c.isUnique && !(unique{}).Apply(all, dirty) {
+		return false
+	}
+	// Each decision must leave a total the others can make up.
+	mins, maxs := make([]int, len(all)), make([]int, len(all))
+	lo, hi := 0, 0
+	for i, d := range all {
+		mins[i], maxs[i] = c.weights.bounds(d.Values())
+		lo += mins[i]
+		hi += maxs[i]
+	}
+	if c.target < lo || c.target > hi {
+		return false
+	}
+	product := 1
+	for i, d := range all {
+		for _, v := range d.Values() {
+			if w := c.weights.weight(v); w < c.target-(hi-maxs[i]) || w > c.target-(lo-mins[i]) {
+				d.Restrict(v)
+			}
+		}
+		if product <= sumEnumerate {
+			product *= d.Count()
+		}
+	}
+	if product > sumEnumerate {
+		return true
+	}
+	// Small groups are searched for the values that can still be
+	// part of a total, pruning with the bounds of the decisions left.
+	sufMin, sufMax := make([]int, len(all)+1), make([]int, len(all)+1)
+	for i := len(all) - 1; i >= 0; i-- {
+		mins[i], maxs[i] = c.weights.bounds(all[i].Values())
+		sufMin[i] = sufMin[i+1] + mins[i]
+		sufMax[i] = sufMax[i+1] + maxs[i]
+	}
+	b := NewBuildupSet(len(all))
+	used := map[int]bool{}
+	var f func(i, total int)
+	f = func(i, total int) {
+		if i == len(all) || total+sufMin[i] > c.target || total+sufMax[i] < c.target {
+			return
+		}
+		for _, v := range all[i].Values() {
+			if c.isUnique && used[v] {
+				continue
+			}
+			t := total + c.weights.weight(v)
+			if i == len(all)-1 && t != c.target {
+				continue
+			}
+			used[v] = true
+			b.Push(v)
+			f(i+1, t)
+			b.Pop()
+			used[v] = false
+		}
+	}
+	f(0, 0)
+	b.Export(all)
+	return true
+}
+
+func (c sum) Cost() int {
+	return csp.CostLinear
+}
+
+func (c sum) CheckGroup(groupSize, valueSize int) error {
+	return c.weights.check(valueSize)
+}
+
+// EncodeCNF tracks the running total along the group, with a variable
+// for each total a prefix of the group can reach that the rest can
+// still bring to the target.
+func (c sum) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	if c.isUnique {
+		(unique{}).EncodeCNF(f, all)
+	}
+	sufMin, sufMax := make([]int, len(all)+1), make([]int, len(all)+1)
+	for i := len(all) - 1; i >= 0; i-- {
+		least, most := c.weights.bounds(all[i].Values())
+		sufMin[i] = sufMin[i+1] + least
+		sufMax[i] = sufMax[i+1] + most
+	}
+	if c.target < sufMin[0] || c.target > sufMax[0] {
+		f.Add()
+		return
+	}
+	// totals maps each total the prefix can make to the variable that
+	// is true when it does, or to 0 before the first decision.
+	totals := map[int]int{0: 0}
+	for i, d := range all {
+		var keys []int
+		for total := range totals {
+			keys = append(keys, total)
+		}
+		sort.Ints(keys)
+		next := map[int]int{}
+		for _, total := range keys {
+			for _, v := range d.Values() {
+				clause := []int{-f.Is(d, v)}
+				if x := totals[total]; x != 0 {
+					clause = append(clause, -x)
+				}
+				t := total + c.weights.weight(v)
+				if t+sufMin[i+1] > c.target || t+sufMax[i+1] < c.target {
+					f.Add(clause...)
+					continue
+				}
+				if i == len(all)-1 {
+					// The last decision makes the target exactly.
+					continue
+				}
+				if _, ok := next[t]; !ok {
+					next[t] = f.NewVar()
+				}
+				f.Add(append(clause, next[t])...)
+			}
+		}
+		totals = next
+	}
+}
+
+type sumParams struct {
+	Target  int   `json:"target"`
+	Weights []int `json:"weights,omitempty"`
+	Unique  bool  `json:"unique"`
+}
+
+func (c sum) MarshalConstraint() (string, interface{}) {
+	return "sum", sumParams{c.target, []int(c.weights), c.isUnique}
+}
+
+type cage struct {
+	op      Op
+	result  int
+	weights valueWeights
+}
+
+func (c cage) Init(all []*csp.Decision, size int) {}
+
+func (c cage) Apply(all, dirty []*csp.Decision) bool {
+	switch c.op {
+	case Add:
+		return sum{c.result, c.weights, false}.Apply(all, dirty)
+	case Multiply:
+		if !c.multiplyBounds(all) {
+			return false
+		}
+	}
+	// As for Sum, only small cages are searched; a cage too big to
+	// search still has values left to decide, so holds for now.
+	product := 1
+	for _, d := range all {
+		if product <= sumEnumerate {
+			product *= d.Count()
+		}
+	}
+	if product > sumEnumerate {
+		return true
+	}
+	b := NewBuildupSet(len(all))
+	ws := make([]int, len(all))
+	var f func(i int)
+	f = func(i int) {
+		for _, v := range all[i].Values() {
+			ws[i] = c.weights.weight(v)
+			if i == len(all)-1 && !c.holds(c.op, ws) {
+				continue
+			}
+			b.Push(v)
+			if i < len(all)-1 {
+				f(i + 1)
+			}
+			b.Pop()
+		}
+	}
+	if len(all) > 0 {
+		f(0)
+	}
+	b.Export(all)
+	return true
+}
+
+// multiplyBounds removes the values of a Multiply cage that do not
+// divide the result, or that the least and greatest products of the
+// other decisions rule out, reporting false if no product can make
+// the result. It leaves cages with weights below 1 alone.
+func (c cage) multiplyBounds(all []*csp.Decision) bool {
+	mins, maxs := make([]int, len(all)), make([]int, len(all))
+	for i, d := range all {
+		mins[i], maxs[i] = c.weights.bounds(d.Values())
+		if mins[i] < 1 {
+			return true
+		}
+	}
+	// Products are capped just past the result, which is as far as
+	// they need comparing.
+	limit := c.result + 1
+	if limit < 1 {
+		return false
+	}
+	for i, d := range all {
+		lo, hi := 1, 1
+		for j := range all {
+			if j != i {
+				lo, hi = cappedProduct(lo, mins[j], limit), cappedProduct(hi, maxs[j], limit)
+			}
+		}
+		for _, v := range d.Values() {
+			w := c.weights.weight(v)
+			if c.result%w != 0 || cappedProduct(w, lo, limit) > c.result || cappedProduct(w, hi, limit) < c.result {
+				d.Restrict(v)
+			}
+		}
+		if d.Count() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cappedProduct returns a*b, or limit if that is more, for positive a
+// and b.
+func cappedProduct(a, b, limit int) int {
+	if a > limit/b {
+		return limit
+	}
+	return a * b
+}
+
+// holds reports whether op combines ws into the cage's result.
+func (c cage) holds(op Op, ws []int) bool {
+	switch op {
+	case Add:
+		total := 0
+		for _, w := range ws {
+			total += w
+		}
+		return total == c.result
+	case Multiply:
+		total := 1
+		for _, w := range ws {
+			total *= w
+		}
+		return total == c.result
+	case Subtract, Divide:
+		largest := 0
+		for i, w := range ws {
+			if w > ws[largest] {
+				largest = i
+			}
+		}
+		total := ws[largest]
+		for i, w := range ws {
+			if i == largest {
+				continue
+			}
+			if op == Subtract {
+				total -= w
+			} else if w == 0 || total%w != 0 {
+				return false
+			} else {
+				total /= w
+			}
+		}
+		return total == c.result
+	case AnyOp:
+		for op := Add; op < AnyOp; op++ {
+			if c.holds(op, ws) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c cage) Cost() int {
+	return csp.CostExpensive
+}
+
+func (c cage) CheckGroup(groupSize, valueSize int) error {
+	return c.weights.check(valueSize)
+}
+
+// EncodeCNF lists the combinations of values left that make the result,
+// and encodes them as for Table.
+func (c cage) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	if c.op == Add {
+		sum{c.result, c.weights, false}.EncodeCNF(f, all)
+		return
+	}
+	if len(all) == 0 {
+		return
+	}
+	var tuples [][]int
+	t, ws := make([]int, len(all)), make([]int, len(all))
+	var g func(i int)
+	g = func(i int) {
+		for _, v := range all[i].Values() {
+			t[i], ws[i] = v, c.weights.weight(v)
+			if i < len(all)-1 {
+				g(i + 1)
+			} else if c.holds(c.op, ws) {
+				tuples = append(tuples, append([]int(nil), t...))
+			}
+		}
+	}
+	g(0)
+	table{tuples: tuples}.EncodeCNF(f, all)
+}
+
+type cageParams struct {
+	Op      Op    `json:"op"`
+	Result  int   `json:"result"`
+	Weights []int `json:"weights,omitempty"`
+}
+
+func (c cage) MarshalConstraint() (string, interface{}) {
+	return "cage", cageParams{c.op, c.result, []int(c.weights)}
+}
+
+// A relation is a test between neighbouring values in a pairwise
+// constraint.
+type relation int
+
+const (
+	lessThan relation = iota
+	differAtLeast
+	differBy
+	ratio
+)
+
+type pairwise struct {
+	relation relation
+	k        int
+	weights  valueWeights
+}
+
+// holds reports whether value a may be followed by value b.
+func (c pairwise) holds(a, b int) bool {
+	x, y := c.weights.weight(a), c.weights.weight(b)
+	switch c.relation {
+	case lessThan:
+		return x < y
+	case differAtLeast:
+		return x-y >= c.k || y-x >= c.k
+	case differBy:
+		return x-y == c.k || y-x == c.k
+	case ratio:
+		return x == c.k*y || y == c.k*x
+	}
+	return false
+}
+
+func (c pairwise) Init(all []*csp.Decision, size int) {}
+
+// Apply removes each value that no remaining value of a neighbour can
+// stand next to. Repeated to a fixpoint by propagation, this carries
+// bounds along a whole thermometer.
+func (c pairwise) Apply(all, dirty []*csp.Decision) bool {
+	for i := 0; i+1 < len(all); i++ {
+		a, b := all[i], all[i+1]
+		as, bs := a.Values(), b.Values()
+		for _, u := range as {
+			if !c.supported(func(v int) bool { return c.holds(u, v) }, bs) {
+				a.Restrict(u)
+			}
+		}
+		as = a.Values()
+		for _, v := range bs {
+			if !c.supported(func(u int) bool { return c.holds(u, v) }, as) {
+				b.Restrict(v)
+			}
+		}
+	}
+	return true
+}
+
+// supported reports whether any of values passes ok.
+func (c pairwise) supported(ok func(int) bool, values []int) bool {
+	for _, v := range values {
+		if ok(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c pairwise) Cost() int {
+	return csp.CostLinear
+}
+
+func (c pairwise) CheckGroup(groupSize, valueSize int) error {
+	return c.weights.check(valueSize)
+}
+
+func (c pairwise) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for i := 0; i+1 < len(all); i++ {
+		for u := 0; u < f.ValueSize(); u++ {
+			for v := 0; v < f.ValueSize(); v++ {
+				if !c.holds(u, v) {
+					f.Add(-f.Is(all[i], u), -f.Is(all[i+1], v))
+				}
+			}
+		}
+	}
+}
+
+type pairwiseParams struct {
+	Relation relation `json:"relation"`
+	K        int      `json:"k,omitempty"`
+	Weights  []int    `json:"weights,omitempty"`
+}
+
+func (c pairwise) MarshalConstraint() (string, interface{}) {
+	return "pairwise", pairwiseParams{c.relation, c.k, []int(c.weights)}
+}
+
 type validWord struct {
 	t        *trie.Trie
 	valueSet []string
@@ -162,6 +909,10 @@ func (c validWord) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c validWord) Cost() int {
+	return csp.CostExpensive
+}
+
 type BuildupSet struct {
 	size, cursor int
 	values       []int
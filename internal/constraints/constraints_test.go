@@ -1,9 +1,323 @@
 package constraints
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
+
+	"github.com/offpath/puzzleutils/internal/csp"
 )
 
 func TestTest(t *testing.T) {
 
 }
+
+var digits = []int{1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+// propagate applies c to a group of size decisions, each with nine
+// values, and returns the values left for each, or nil if
+// propagation fails.
+func propagate(size int, c csp.ConstraintChecker) [][]int {
+	p := csp.NewProblem(size, 9)
+	var group []int
+	for i := 0; i < size; i++ {
+		group = append(group, i)
+	}
+	p.AddGroup(group, c)
+	if _, ok := p.Propagate(csp.Settings{}); !ok {
+		return nil
+	}
+	var result [][]int
+	for i := 0; i < size; i++ {
+		result = append(result, p.Get(i).Values())
+	}
+	return result
+}
+
+func TestSum(t *testing.T) {
+	for _, tt := range []struct {
+		test     string
+		size     int
+		target   int
+		weights  []int
+		isUnique bool
+		// want holds the values left for each decision after
+		// propagation, or nil if it should fail.
+		want [][]int
+	}{
+		{"pair", 2, 3, nil, false, [][]int{{0, 1, 2, 3}, {0, 1, 2, 3}}},
+		{"unique pair", 2, 17, digits, true, [][]int{{7, 8}, {7, 8}}},
+		{"low triple", 3, 6, digits, true, [][]int{{0, 1, 2}, {0, 1, 2}, {0, 1, 2}}},
+		{"high triple", 3, 24, digits, true, [][]int{{6, 7, 8}, {6, 7, 8}, {6, 7, 8}}},
+		{"repeats", 2, 2, digits, false, [][]int{{0}, {0}}},
+		{"no repeats", 2, 2, digits, true, nil},
+		{"too big", 2, 19, digits, false, nil},
+		{"weights", 2, 10, []int{0, 5, 10, 0, 0, 0, 0, 0, 0}, true, [][]int{{0, 2, 3, 4, 5, 6, 7, 8}, {0, 2, 3, 4, 5, 6, 7, 8}}},
+		{"hidden", 3, 10, digits, true, [][]int{{0, 1, 2, 3, 4, 5, 6}, {0, 1, 2, 3, 4, 5, 6}, {0, 1, 2, 3, 4, 5, 6}}},
+	} {
+		if got := propagate(tt.size, Sum(tt.target, tt.weights, tt.isUnique)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestSumBounds(t *testing.T) {
+	// A cage too big to search is pruned by bounds alone.
+	p := csp.NewProblem(9, 9)
+	p.AddGroup([]int{0, 1, 2, 3, 4, 5, 6, 7, 8}, Sum(12, digits, false))
+	if _, ok := p.Propagate(csp.Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	if got := p.Get(0).Values(); fmt.Sprint(got) != "[0 1 2 3]" {
+		t.Errorf("got %v, want values up to 4", got)
+	}
+}
+
+func TestWeightsJSON(t *testing.T) {
+	for _, tt := range []struct {
+		test string
+		data string
+		ok   bool
+	}{
+		{"no weights", `{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"sum","params":{"target":3}}]}`, true},
+		{"weights", `{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"sum","params":{"target":3,"weights":[1,2,3]}}]}`, true},
+		{"short sum", `{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"sum","params":{"target":3,"weights":[1,2]}}]}`, false},
+		{"short pairwise", `{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"pairwise","params":{"relation":0,"weights":[1,2]}}]}`, false},
+		{"short cage", `{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"cage","params":{"op":2,"result":6,"weights":[1,2]}}]}`, false},
+	} {
+		if err := json.Unmarshal([]byte(tt.data), &csp.Problem{}); (err == nil) != tt.ok {
+			t.Errorf("test: %s, got error %v", tt.test, err)
+		}
+	}
+}
+
+func TestArithmeticJSONErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"cage","params":{"op":5,"result":6}}]}`,
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"cage","params":{"op":-1,"result":6}}]}`,
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"pairwise","params":{"relation":4}}]}`,
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"pairwise","params":{"relation":-1}}]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &csp.Problem{}); err == nil {
+			t.Errorf("loaded %s", data)
+		}
+	}
+}
+
+func TestCage(t *testing.T) {
+	for _, tt := range []struct {
+		test   string
+		size   int
+		op     Op
+		result int
+		want   [][]int
+	}{
+		{"add", 2, Add, 17, [][]int{{7, 8}, {7, 8}}},
+		{"subtract", 2, Subtract, 8, [][]int{{0, 8}, {0, 8}}},
+		{"multiply", 2, Multiply, 12, [][]int{{1, 2, 3, 5}, {1, 2, 3, 5}}},
+		{"divide", 2, Divide, 3, [][]int{{0, 1, 2, 5, 8}, {0, 1, 2, 5, 8}}},
+		{"subtract three", 3, Subtract, 6, [][]int{{0, 1, 7, 8}, {0, 1, 7, 8}, {0, 1, 7, 8}}},
+		{"any", 2, AnyOp, 20, [][]int{{3, 4}, {3, 4}}},
+		{"impossible", 2, Multiply, 11, nil},
+		{"multiply bounds", 3, Multiply, 5, [][]int{{0, 4}, {0, 4}, {0, 4}}},
+		// Nine cells are too many to search, so these rely on bounds.
+		{"large add", 9, Add, 10, repeat(9, []int{0, 1})},
+		{"large multiply", 9, Multiply, 7, repeat(9, []int{0, 6})},
+		{"large divide", 9, Divide, 9, repeat(9, []int{0, 1, 2, 3, 4, 5, 6, 7, 8})},
+		{"large multiply impossible", 9, Multiply, 11, nil},
+	} {
+		if got := propagate(tt.size, Cage(tt.op, tt.result, digits)); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+// repeat returns n copies of values.
+func repeat(n int, values []int) [][]int {
+	var result [][]int
+	for i := 0; i < n; i++ {
+		result = append(result, values)
+	}
+	return result
+}
+
+func TestPairwise(t *testing.T) {
+	for _, tt := range []struct {
+		test string
+		size int
+		c    csp.ConstraintChecker
+		want [][]int
+	}{
+		{"less than", 2, LessThan(digits), [][]int{{0, 1, 2, 3, 4, 5, 6, 7}, {1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"thermometer", 9, LessThan(digits), [][]int{{0}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}}},
+		{"long thermometer", 10, LessThan(digits), nil},
+		{"whisper", 2, DifferAtLeast(5, digits), [][]int{{0, 1, 2, 3, 5, 6, 7, 8}, {0, 1, 2, 3, 5, 6, 7, 8}}},
+		{"white dot", 2, DifferBy(1, digits), [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8}, {0, 1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"black dot", 2, Ratio(2, digits), [][]int{{0, 1, 2, 3, 5, 7}, {0, 1, 2, 3, 5, 7}}},
+		{"weights", 3, LessThan([]int{0, 5, 5, 10, 0, 0, 0, 0, 0}), [][]int{{0, 4, 5, 6, 7, 8}, {1, 2}, {3}}},
+	} {
+		if got := propagate(tt.size, tt.c); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestPairwiseJSON(t *testing.T) {
+	for _, c := range []csp.ConstraintChecker{LessThan(digits), DifferAtLeast(5, digits), DifferBy(1, nil), Ratio(2, digits)} {
+		p := csp.NewProblem(2, 9)
+		p.AddGroup([]int{0, 1}, c)
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		loaded := &csp.Problem{}
+		if err := json.Unmarshal(data, loaded); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if got := loaded.Groups()[0].Constraint(); fmt.Sprint(got) != fmt.Sprint(c) {
+			t.Errorf("got %v, want %v", got, c)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	rotations := [][]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}, {0, 2, 1}}
+	for _, tt := range []struct {
+		test string
+		c    csp.ConstraintChecker
+		// given restricts the first decisions to single values.
+		given []int
+		want  [][]int
+	}{
+		{"table", Table(rotations), nil, [][]int{{0, 1, 2}, {0, 1, 2}, {0, 1, 2}}},
+		{"table given", Table(rotations), []int{0}, [][]int{{0}, {1, 2}, {1, 2}}},
+		{"table givens", Table(rotations), []int{0, 1}, [][]int{{0}, {1}, {2}}},
+		{"table none left", Table(rotations), []int{1, 1}, nil},
+		{"empty table", Table(nil), nil, nil},
+		{"forbidden", Forbidden([][]int{{0, 0, 0}}), []int{0, 0}, [][]int{{0}, {0}, {1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"forbidden all", Forbidden([][]int{{0, 0, 0}}), []int{0, 0, 0}, nil},
+		{"forbidden repeated", Forbidden([][]int{{0, 0, 0}, {0, 0, 0}, {0, 0, 1}}), []int{0, 0}, [][]int{{0}, {0}, {2, 3, 4, 5, 6, 7, 8}}},
+	} {
+		p := csp.NewProblem(3, 9)
+		p.AddGroup([]int{0, 1, 2}, tt.c)
+		for i, v := range tt.given {
+			p.Get(i).RestrictTo(v)
+		}
+		var got [][]int
+		if _, ok := p.Propagate(csp.Settings{}); ok {
+			for i := 0; i < 3; i++ {
+				got = append(got, p.Get(i).Values())
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestForbiddenCombinations(t *testing.T) {
+	// With two values each, ruling out three of the four pairs leaves
+	// only the fourth.
+	p := csp.NewProblem(2, 2)
+	p.AddGroup([]int{0, 1}, Forbidden([][]int{{0, 0}, {0, 1}, {1, 0}}))
+	if _, ok := p.Propagate(csp.Settings{}); !ok || p.Get(0).Value() != 1 || p.Get(1).Value() != 1 {
+		t.Errorf("got %v and %v, want [1] and [1]", p.Get(0).Values(), p.Get(1).Values())
+	}
+}
+
+func TestTableGroupSize(t *testing.T) {
+	for _, data := range []string{
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"table","params":{"tuples":[[0]]}}]}`,
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"forbidden","params":{"tuples":[[0,1,2]]}}]}`,
+		`{"valueSize":3,"decisions":[[0,1,2],[0,1,2]],"groups":[{"decisions":[0,1],"constraint":"table","params":{"tuples":[[0,3]]}}]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &csp.Problem{}); err == nil {
+			t.Errorf("loaded %s", data)
+		}
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("a table of pairs was added to a group of three")
+		}
+	}()
+	p := csp.NewProblem(3, 3)
+	p.AddGroup([]int{0, 1, 2}, Table([][]int{{0, 1}}))
+}
+
+func TestMDD(t *testing.T) {
+	// The tuples share their suffixes, so the first two children of
+	// the root are one node, and both nodes at the second level
+	// share their child.
+	root := newMDD([][]int{{0, 0, 0}, {1, 0, 0}, {2, 1, 0}, {2, 1, 0}})
+	if len(root.next) != 3 || root.next[0] != root.next[1] {
+		t.Fatalf("got %d children of the root, want 3 with the first two shared", len(root.next))
+	}
+	if a, b := root.next[0], root.next[2]; len(a.next) != 1 || len(b.next) != 1 || a.next[0] != b.next[0] {
+		t.Errorf("second level nodes do not share their child")
+	}
+}
+
+func TestRegularExpression(t *testing.T) {
+	values := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	for _, tt := range []struct {
+		test string
+		expr string
+		size int
+		want [][]int
+	}{
+		{"literal", "123", 3, [][]int{{0}, {1}, {2}}},
+		{"class", "[1-3][^1-8]", 2, [][]int{{0, 1, 2}, {8}}},
+		{"alternation", "12|34|5.", 2, [][]int{{0, 2, 4}, {0, 1, 2, 3, 4, 5, 6, 7, 8}}},
+		{"repeat", "1*2+1*", 3, [][]int{{0, 1}, {0, 1}, {0, 1}}},
+		{"count", "(12){2}", 4, [][]int{{0}, {1}, {0}, {1}}},
+		{"anchors", "^9?1$", 2, [][]int{{8}, {0}}},
+		{"too short", "1{4}", 3, nil},
+	} {
+		c, err := RegularExpression(tt.expr, values)
+		if err != nil {
+			t.Errorf("test: %s, %v", tt.test, err)
+			continue
+		}
+		if got := propagate(tt.size, c); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, got, tt.want)
+		}
+	}
+}
+
+func TestRegularExpressionErrors(t *testing.T) {
+	for _, tt := range []struct {
+		test   string
+		expr   string
+		values []string
+	}{
+		{"syntax", "1(", []string{"1"}},
+		{"word boundary", `\b1`, []string{"1"}},
+		{"long value", "1", []string{"10"}},
+	} {
+		if _, err := RegularExpression(tt.expr, tt.values); err == nil {
+			t.Errorf("test: %s, got no error", tt.test)
+		}
+	}
+}
+
+func TestRegular(t *testing.T) {
+	// Values 0 and 1 with no two 1s in a row, ending in 1.
+	c := Regular(DFA{
+		Next:   [][]int{{0, 1}, {0, -1}},
+		Accept: []bool{false, true},
+	})
+	p := csp.NewProblem(4, 2)
+	p.AddGroup([]int{0, 1, 2, 3}, c)
+	p.Get(1).RestrictTo(1)
+	if _, ok := p.Propagate(csp.Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	var got []int
+	for i := 0; i < 4; i++ {
+		got = append(got, p.Get(i).Value())
+	}
+	if fmt.Sprint(got) != "[0 1 0 1]" {
+		t.Errorf("got %v, want [0 1 0 1]", got)
+	}
+}
@@ -0,0 +1,297 @@
+package constraints
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"sort"
+
+	"github.com/offpath/puzzleutils/internal/cnf"
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+// A DFA is a deterministic finite automaton over a group's values. It
+// starts in state 0, and Next[s][v] is its state after reading value v
+// in state s, or -1 if v cannot follow. It accepts a sequence of
+// values that leaves it in a state s with Accept[s] set.
+type DFA struct {
+	Next   [][]int `json:"next"`
+	Accept []bool  `json:"accept"`
+}
+
+func (a DFA) next(s, v int) int {
+	if v >= len(a.Next[s]) {
+		return -1
+	}
+	return a.Next[s][v]
+}
+
+// Regular requires the values along a group to be accepted by a. It
+// keeps every remaining value on some accepted path through the
+// automaton that the other decisions still allow. It panics if a is
+// malformed.
+func Regular(a DFA) csp.ConstraintChecker {
+	if err := a.check(); err != nil {
+		panic(err)
+	}
+	return regular{a}
+}
+
+// check reports whether a has a start state, an Accept entry for
+// each state, and transitions only to states it has.
+func (a DFA) check() error {
+	if len(a.Next) == 0 || len(a.Accept) != len(a.Next) {
+		return errors.New("constraints: DFA needs a start state and one Accept entry per state")
+	}
+	for s, row := range a.Next {
+		for v, t := range row {
+			if t < -1 || t >= len(a.Next) {
+				return fmt.Errorf("constraints: DFA goes from state %d on value %d to missing state %d", s, v, t)
+			}
+		}
+	}
+	return nil
+}
+
+// RegularExpression requires the values along a group, each written
+// as its single character in valueSet, to match expr in full. The
+// syntax is that of package regexp, without word boundaries.
+func RegularExpression(expr string, valueSet []string) (csp.ConstraintChecker, error) {
+	a, err := compileDFA(expr, valueSet)
+	if err != nil {
+		return nil, err
+	}
+	return Regular(a), nil
+}
+
+const (
+	beginText = syntax.EmptyBeginLine | syntax.EmptyBeginText
+	endText   = syntax.EmptyEndLine | syntax.EmptyEndText
+)
+
+// compileDFA builds a DFA for expr by the subset construction over the
+// instructions of its compiled program. A state holds the
+// instructions to resume from, so that zero-width assertions can be
+// checked against where in the group it is reached.
+func compileDFA(expr string, valueSet []string) (DFA, error) {
+	var runes []rune
+	for _, v := range valueSet {
+		r := []rune(v)
+		if len(r) != 1 {
+			return DFA{}, fmt.Errorf("constraints: value %q is not a single character", v)
+		}
+		runes = append(runes, r[0])
+	}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return DFA{}, err
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return DFA{}, err
+	}
+	for _, inst := range prog.Inst {
+		if inst.Op == syntax.InstEmptyWidth && syntax.EmptyOp(inst.Arg)&^(beginText|endText) != 0 {
+			return DFA{}, fmt.Errorf("constraints: %q uses word boundaries", expr)
+		}
+	}
+
+	var a DFA
+	var states [][]uint32
+	ids := map[string]int{}
+	add := func(pcs []uint32) int {
+		// The start state is kept apart from any later state with
+		// the same instructions.
+		key := fmt.Sprint(len(states) == 0, pcs)
+		if id, ok := ids[key]; ok {
+			return id
+		}
+		ids[key] = len(states)
+		states = append(states, pcs)
+		return len(states) - 1
+	}
+	add([]uint32{uint32(prog.Start)})
+	for s := 0; s < len(states); s++ {
+		// Only the start state is at the beginning of the group.
+		var flags syntax.EmptyOp
+		if s == 0 {
+			flags = beginText
+		}
+		a.Accept = append(a.Accept, false)
+		for _, pc := range closure(prog, states[s], flags|endText) {
+			a.Accept[s] = a.Accept[s] || prog.Inst[pc].Op == syntax.InstMatch
+		}
+		current := closure(prog, states[s], flags)
+		row := make([]int, len(runes))
+		for v, r := range runes {
+			var next []uint32
+			for _, pc := range current {
+				if inst := prog.Inst[pc]; consumes(inst, r) {
+					next = append(next, inst.Out)
+				}
+			}
+			row[v] = -1
+			if len(next) > 0 {
+				row[v] = add(sortedUnique(next))
+			}
+		}
+		a.Next = append(a.Next, row)
+	}
+	return a, nil
+}
+
+// closure returns the instructions that consume a character or match,
+// reachable from pcs without consuming one, passing only the
+// zero-width assertions in flags.
+func closure(prog *syntax.Prog, pcs []uint32, flags syntax.EmptyOp) []uint32 {
+	seen := map[uint32]bool{}
+	var result []uint32
+	var visit func(pc uint32)
+	visit = func(pc uint32) {
+		if seen[pc] {
+			return
+		}
+		seen[pc] = true
+		inst := prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			visit(inst.Out)
+			visit(inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			visit(inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^flags == 0 {
+				visit(inst.Out)
+			}
+		case syntax.InstFail:
+		default:
+			result = append(result, pc)
+		}
+	}
+	for _, pc := range pcs {
+		visit(pc)
+	}
+	return sortedUnique(result)
+}
+
+func consumes(inst syntax.Inst, r rune) bool {
+	switch inst.Op {
+	case syntax.InstRune:
+		return inst.MatchRune(r)
+	case syntax.InstRune1:
+		return r == inst.Rune[0]
+	case syntax.InstRuneAny:
+		return true
+	case syntax.InstRuneAnyNotNL:
+		return r != '\n'
+	}
+	return false
+}
+
+func sortedUnique(pcs []uint32) []uint32 {
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+	result := pcs[:0]
+	for i, pc := range pcs {
+		if i == 0 || pc != pcs[i-1] {
+			result = append(result, pc)
+		}
+	}
+	return result
+}
+
+type regular struct {
+	a DFA
+}
+
+func (c regular) Init(all []*csp.Decision, size int) {}
+
+// Apply filters the layered graph of the automaton's states after
+// each decision: a forward pass finds the states reachable from the
+// start, a backward pass keeps those that can still reach an
+// accepting state, and a value survives if it joins two such states.
+func (c regular) Apply(all, dirty []*csp.Decision) bool {
+	n, states := len(all), len(c.a.Next)
+	reached := make([][]bool, n+1)
+	for i := range reached {
+		reached[i] = make([]bool, states)
+	}
+	reached[0][0] = true
+	for i, d := range all {
+		for s := 0; s < states; s++ {
+			if !reached[i][s] {
+				continue
+			}
+			for _, v := range d.Values() {
+				if t := c.a.next(s, v); t >= 0 {
+					reached[i+1][t] = true
+				}
+			}
+		}
+	}
+	alive := make([]bool, states)
+	for s := range alive {
+		alive[s] = reached[n][s] && c.a.Accept[s]
+	}
+	supported := make([]map[int]bool, n)
+	for i := n - 1; i >= 0; i-- {
+		supported[i] = map[int]bool{}
+		before := make([]bool, states)
+		for s := 0; s < states; s++ {
+			if !reached[i][s] {
+				continue
+			}
+			for _, v := range all[i].Values() {
+				if t := c.a.next(s, v); t >= 0 && alive[t] {
+					supported[i][v] = true
+					before[s] = true
+				}
+			}
+		}
+		alive = before
+	}
+	if !alive[0] {
+		return false
+	}
+	for i, d := range all {
+		d.RestrictToSet(supported[i])
+	}
+	return true
+}
+
+func (c regular) Cost() int {
+	return csp.CostExpensive
+}
+
+// EncodeCNF gives each state at each position a variable, true along
+// the automaton's run: the start is true, and each state and value
+// imply the next state.
+func (c regular) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	states := len(c.a.Next)
+	at := make([][]int, len(all)+1)
+	for i := range at {
+		for s := 0; s < states; s++ {
+			at[i] = append(at[i], f.NewVar())
+		}
+	}
+	f.Add(at[0][0])
+	for i, d := range all {
+		for s := 0; s < states; s++ {
+			for v := 0; v < f.ValueSize(); v++ {
+				if t := c.a.next(s, v); t >= 0 {
+					f.Add(-at[i][s], -f.Is(d, v), at[i+1][t])
+				} else {
+					f.Add(-at[i][s], -f.Is(d, v))
+				}
+			}
+		}
+	}
+	for s := 0; s < states; s++ {
+		if !c.a.Accept[s] {
+			f.Add(-at[len(all)][s])
+		}
+	}
+}
+
+func (c regular) MarshalConstraint() (string, interface{}) {
+	return "regular", c.a
+}
@@ -0,0 +1,283 @@
+package constraints
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/offpath/puzzleutils/internal/cnf"
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+// Table requires a group's values to form one of tuples, which each
+// hold one value per decision. It keeps every remaining value part of
+// some tuple that the other decisions still allow. It panics if the
+// tuples differ in length, and the group it is added to must be as
+// long as they are.
+func Table(tuples [][]int) csp.ConstraintChecker {
+	if err := checkTuples(tuples); err != nil {
+		panic(err)
+	}
+	return table{tuples, newMDD(tuples)}
+}
+
+// Forbidden requires a group's values not to form any of tuples, which
+// each hold one value per decision. It panics if the tuples differ in
+// length, and the group it is added to must be as long as they are.
+func Forbidden(tuples [][]int) csp.ConstraintChecker {
+	if err := checkTuples(tuples); err != nil {
+		panic(err)
+	}
+	// Apply counts tuples, so each must appear once.
+	var unique [][]int
+	seen := map[string]bool{}
+	for _, t := range tuples {
+		if key := fmt.Sprint(t); !seen[key] {
+			seen[key] = true
+			unique = append(unique, t)
+		}
+	}
+	return forbidden{unique}
+}
+
+func checkTuples(tuples [][]int) error {
+	for _, t := range tuples {
+		if len(t) != len(tuples[0]) {
+			return errors.New("constraints: tuples differ in length")
+		}
+	}
+	return nil
+}
+
+// checkTupleGroup reports whether tuples suit a group of groupSize
+// decisions with valueSize values each.
+func checkTupleGroup(tuples [][]int, groupSize, valueSize int) error {
+	for _, t := range tuples {
+		if len(t) != groupSize {
+			return fmt.Errorf("constraints: tuples of %d values for a group of %d", len(t), groupSize)
+		}
+		for _, v := range t {
+			if v < 0 || v >= valueSize {
+				return fmt.Errorf("constraints: tuple value %d out of range", v)
+			}
+		}
+	}
+	return nil
+}
+
+// An mddNode is a node of a multi-valued decision diagram: a trie of
+// the tuples in which identical subtries are shared. Nodes at the last
+// level have no edges.
+type mddNode struct {
+	values []int
+	next   []*mddNode
+}
+
+func (n *mddNode) child(v int) *mddNode {
+	for i, u := range n.values {
+		if u == v {
+			return n.next[i]
+		}
+	}
+	child := &mddNode{}
+	n.values = append(n.values, v)
+	n.next = append(n.next, child)
+	return child
+}
+
+func newMDD(tuples [][]int) *mddNode {
+	root := &mddNode{}
+	for _, t := range tuples {
+		n := root
+		for _, v := range t {
+			n = n.child(v)
+		}
+	}
+	return root.merge(map[string]*mddNode{})
+}
+
+// merge returns the node shared by every subtrie equal to n's.
+func (n *mddNode) merge(nodes map[string]*mddNode) *mddNode {
+	sort.Sort(byValue{n})
+	var key strings.Builder
+	for i, v := range n.values {
+		n.next[i] = n.next[i].merge(nodes)
+		fmt.Fprintf(&key, "%d:%p,", v, n.next[i])
+	}
+	if shared, ok := nodes[key.String()]; ok {
+		return shared
+	}
+	nodes[key.String()] = n
+	return n
+}
+
+type byValue struct{ n *mddNode }
+
+func (b byValue) Len() int           { return len(b.n.values) }
+func (b byValue) Less(i, j int) bool { return b.n.values[i] < b.n.values[j] }
+func (b byValue) Swap(i, j int) {
+	b.n.values[i], b.n.values[j] = b.n.values[j], b.n.values[i]
+	b.n.next[i], b.n.next[j] = b.n.next[j], b.n.next[i]
+}
+
+type table struct {
+	tuples [][]int
+	root   *mddNode
+}
+
+func (c table) Init(all []*csp.Decision, size int) {}
+
+func (c table) Apply(all, dirty []*csp.Decision) bool {
+	if len(c.tuples) == 0 {
+		return false
+	}
+	supported := make([]map[int]bool, len(all))
+	for i := range supported {
+		supported[i] = map[int]bool{}
+	}
+	// Each node is at one level, so whether it still reaches the end
+	// can be remembered across the paths that share it.
+	reaches := map[*mddNode]bool{}
+	var visit func(n *mddNode, level int) bool
+	visit = func(n *mddNode, level int) bool {
+		if level == len(all) {
+			return true
+		}
+		if r, ok := reaches[n]; ok {
+			return r
+		}
+		found := false
+		for i, v := range n.values {
+			if all[level].Possible(v) && visit(n.next[i], level+1) {
+				supported[level][v] = true
+				found = true
+			}
+		}
+		reaches[n] = found
+		return found
+	}
+	if !visit(c.root, 0) {
+		return false
+	}
+	for i, d := range all {
+		d.RestrictToSet(supported[i])
+	}
+	return true
+}
+
+func (c table) CheckGroup(groupSize, valueSize int) error {
+	return checkTupleGroup(c.tuples, groupSize, valueSize)
+}
+
+func (c table) Cost() int {
+	return csp.CostExpensive
+}
+
+func (c table) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	// One variable per tuple, which implies each of its values.
+	var clause []int
+	for _, t := range c.tuples {
+		x := f.NewVar()
+		for i, v := range t {
+			f.Add(-x, f.Is(all[i], v))
+		}
+		clause = append(clause, x)
+	}
+	f.Add(clause...)
+}
+
+type tableParams struct {
+	Tuples [][]int `json:"tuples"`
+}
+
+func (c table) MarshalConstraint() (string, interface{}) {
+	return "table", tableParams{c.tuples}
+}
+
+type forbidden struct {
+	tuples [][]int
+}
+
+func (c forbidden) Init(all []*csp.Decision, size int) {}
+
+// Apply removes a value when every combination of the other
+// decisions' values that goes with it is forbidden, found by counting
+// the forbidden tuples that are still possible.
+func (c forbidden) Apply(all, dirty []*csp.Decision) bool {
+	counts := make([]map[int]int, len(all))
+	for i := range counts {
+		counts[i] = map[int]int{}
+	}
+	matches := 0
+	for _, t := range c.tuples {
+		if !possible(all, t) {
+			continue
+		}
+		matches++
+		for i, v := range t {
+			counts[i][v]++
+		}
+	}
+	if matches == 0 {
+		return true
+	}
+	// The counts hold for the domains as they were before any value
+	// is removed.
+	sizes := make([]int, len(all))
+	for i, d := range all {
+		sizes[i] = d.Count()
+	}
+	for i, d := range all {
+		// The number of combinations of the others, if no more than
+		// the tuples.
+		others := 1
+		for j, size := range sizes {
+			if j != i && others <= matches {
+				others *= size
+			}
+		}
+		if others > matches {
+			continue
+		}
+		for v, n := range counts[i] {
+			if n == others {
+				d.Restrict(v)
+			}
+		}
+	}
+	return true
+}
+
+// possible reports whether each decision can still take its value in
+// t.
+func possible(all []*csp.Decision, t []int) bool {
+	for i, v := range t {
+		if !all[i].Possible(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c forbidden) CheckGroup(groupSize, valueSize int) error {
+	return checkTupleGroup(c.tuples, groupSize, valueSize)
+}
+
+func (c forbidden) Cost() int {
+	return csp.CostLinear
+}
+
+func (c forbidden) EncodeCNF(f *cnf.Formula, all []*csp.Decision) {
+	for _, t := range c.tuples {
+		var clause []int
+		for i, v := range t {
+			clause = append(clause, -f.Is(all[i], v))
+		}
+		f.Add(clause...)
+	}
+}
+
+func (c forbidden) MarshalConstraint() (string, interface{}) {
+	return "forbidden", tableParams{c.tuples}
+}
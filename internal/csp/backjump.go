@@ -0,0 +1,155 @@
+package csp
+
+import (
+	"math/bits"
+)
+
+// At most this many nogoods are learned in a single search.
+const maxNogoods = 1000
+
+// A levelSet is a set of search levels, kept as a bitset. Level 0,
+// for restrictions made before the search, is never included. Sets
+// are shared between decisions, so operations return a new set
+// rather than modifying their receiver.
+type levelSet []uint64
+
+// allLevels returns the set of levels 1 through n.
+func allLevels(n int) levelSet {
+	var result levelSet
+	for l := 1; l <= n; l++ {
+		result = result.with(l)
+	}
+	return result
+}
+
+func (s levelSet) has(l int) bool {
+	return l > 0 && l/64 < len(s) && s[l/64]&(1<<(l%64)) != 0
+}
+
+func (s levelSet) with(l int) levelSet {
+	if l <= 0 || s.has(l) {
+		return s
+	}
+	n := len(s)
+	if l/64 >= n {
+		n = l/64 + 1
+	}
+	result := make(levelSet, n)
+	copy(result, s)
+	result[l/64] |= 1 << (l % 64)
+	return result
+}
+
+func (s levelSet) without(l int) levelSet {
+	if !s.has(l) {
+		return s
+	}
+	result := append(levelSet(nil), s...)
+	result[l/64] &^= 1 << (l % 64)
+	return result
+}
+
+// union returns s itself if t adds nothing to it.
+func (s levelSet) union(t levelSet) levelSet {
+	subset := len(t) <= len(s)
+	for i := 0; subset && i < len(t); i++ {
+		subset = t[i]&^s[i] == 0
+	}
+	if subset {
+		return s
+	}
+	if len(s) < len(t) {
+		s, t = t, s
+	}
+	result := append(levelSet(nil), s...)
+	for i, w := range t {
+		result[i] |= w
+	}
+	return result
+}
+
+// levels returns the levels in the set in ascending order.
+func (s levelSet) levels() []int {
+	var result []int
+	for i, w := range s {
+		for ; w != 0; w &= w - 1 {
+			result = append(result, i*64+bits.TrailingZeros64(w))
+		}
+	}
+	return result
+}
+
+// A guess records the assignments made at a level of the search.
+type guess struct {
+	level int
+	as    []assignment
+}
+
+// learn records the guesses at the levels in conflict as a nogood,
+// a combination that can never be part of a solution.
+func (sv *solver) learn(conflict levelSet) {
+	if !sv.LearnNogoods || len(sv.nogoods) >= maxNogoods || len(sv.guesses) == 0 {
+		return
+	}
+	levels := conflict.levels()
+	if len(levels) == 0 {
+		// The search is about to end anyway.
+		return
+	}
+	var nogood []assignment
+	for _, l := range levels {
+		i := l - sv.guesses[0].level
+		if i < 0 || i >= len(sv.guesses) {
+			// The conflict involves levels from before the search.
+			return
+		}
+		nogood = append(nogood, sv.guesses[i].as...)
+	}
+	sv.nogoods = append(sv.nogoods, nogood)
+}
+
+// applyNogoods removes any value that would complete a learned
+// nogood, propagating as it goes, and returns false on a conflict or
+// if a nogood is already complete.
+func (sv *solver) applyNogoods() bool {
+	p := sv.p
+	for changed := true; changed; {
+		changed = false
+		for _, nogood := range sv.nogoods {
+			var open *Decision
+			openValue, unassigned := 0, 0
+			for _, a := range nogood {
+				d := p.decisions[a.d]
+				if !d.Possible(a.value) {
+					unassigned = -1
+					break
+				}
+				if d.Value() != a.value {
+					open, openValue = d, a.value
+					unassigned++
+				}
+			}
+			if unassigned < 0 || unassigned > 1 {
+				continue
+			}
+			var reason levelSet
+			for _, a := range nogood {
+				if d := p.decisions[a.d]; d != open {
+					reason = reason.union(d.reason)
+				}
+			}
+			if open == nil {
+				p.conflictReason = reason
+				return false
+			}
+			p.explain = reason
+			open.Restrict(openValue)
+			p.explain = nil
+			if !p.propagate(sv.Settings) {
+				return false
+			}
+			changed = true
+		}
+	}
+	return true
+}
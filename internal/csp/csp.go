@@ -3,75 +3,92 @@
 package csp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
 )
 
+// ErrBudgetExceeded is returned when a search gives up after making
+// Settings.MaxDecisions decisions.
+var ErrBudgetExceeded = errors.New("csp: decision budget exceeded")
+
 // Decision represents a single decision to be made, for example a
 // single grid square in a sudoku or similar puzzle.
 type Decision struct {
-	possibilities map[int]bool
-	groups        []*Group
-	p             *Problem
+	domain domain
+	index  int
+	groups []*Group
+	p      *Problem
+	dirty  bool
+	// reason holds the search levels responsible for the values
+	// removed from the domain, when backjumping.
+	reason levelSet
 }
 
-func newDecision(size int, p *Problem) *Decision {
-	result := Decision{
-		possibilities: map[int]bool{},
-		p:             p,
-	}
-	for i := 0; i < size; i++ {
-		result.possibilities[i] = true
+func newDecision(size int, index int, p *Problem) *Decision {
+	return &Decision{
+		domain: newDomain(size),
+		index:  index,
+		p:      p,
 	}
-	return &result
 }
 
 // Value returns the value of a decision if the decision has been made
 // or -1 if multiple possibilities remain.
 func (d *Decision) Value() int {
-	if len(d.possibilities) != 1 {
+	if d.domain.count != 1 {
 		return -1
 	}
-	for k := range d.possibilities {
-		return k
-	}
-	return -1
+	return d.domain.first()
 }
 
 // Count returns the number of remaining options for the decision.
 func (d *Decision) Count() int {
-	return len(d.possibilities)
-}
-
-func (d *Decision) undo(item undoRestricts) {
-	for _, v := range item {
-		d.possibilities[v] = true
-	}
+	return d.domain.count
 }
 
 // Restrict removes i as a possibility for the decision.
 func (d *Decision) Restrict(i int) {
-	if d.possibilities[i] {
-		delete(d.possibilities, i)
+	if d.domain.remove(i) {
 		d.p.setDirty(d, i)
-		if len(d.possibilities) == 0 {
-			d.p.setConflict()
+		if d.domain.count == 0 {
+			d.p.setConflict(d.reason)
 		}
 	}
 }
 
+// restrictWord removes every possibility held in word j of the
+// domain that is not also in keep.
+func (d *Decision) restrictWord(j int, keep uint64) {
+	w := d.domain.lo
+	if j > 0 {
+		w = d.domain.hi[j-1]
+	}
+	for w &^= keep; w != 0; w &= w - 1 {
+		d.Restrict(j*64 + bits.TrailingZeros64(w))
+	}
+}
+
 // RestrictTo removes everything but i as a possibility for the
 // decision.
 func (d *Decision) RestrictTo(i int) {
-	for k := range d.possibilities {
-		if k != i {
-			d.Restrict(k)
+	for j := 0; j <= len(d.domain.hi); j++ {
+		var keep uint64
+		if i >= 0 && i/64 == j {
+			keep = 1 << (i % 64)
 		}
+		d.restrictWord(j, keep)
 	}
-
 }
 
 func (d *Decision) RestrictToSet(s map[int]bool) {
-	for k := range d.possibilities {
+	var buf [64]int
+	for _, k := range d.domain.values(buf[:0]) {
 		if !s[k] {
 			d.Restrict(k)
 		}
@@ -79,32 +96,126 @@ func (d *Decision) RestrictToSet(s map[int]bool) {
 }
 
 func (d *Decision) RestrictToEqual(d2 *Decision) {
-	d.RestrictToSet(d2.possibilities)
+	d.restrictWord(0, d2.domain.lo)
+	for j := range d.domain.hi {
+		var keep uint64
+		if j < len(d2.domain.hi) {
+			keep = d2.domain.hi[j]
+		}
+		d.restrictWord(j+1, keep)
+	}
 }
 
 func (d *Decision) Possible(i int) bool {
-	return d.possibilities[i]
+	return d.domain.has(i)
+}
+
+// Values returns the remaining possible values in ascending order.
+func (d *Decision) Values() []int {
+	return d.domain.values(nil)
 }
 
 // Group represents a grouping of Decisions over which to apply a
 // constraint, for example a row or column in a sudoku puzzle with a
 // uniqueness constraint.
 type Group struct {
+	name       string
+	index      int
 	decisions  []*Decision
 	constraint ConstraintChecker
+	cost       int
+	// pending holds the dirty decisions to pass to the constraint
+	// when it is next applied.
+	pending []*Decision
+}
+
+// Index returns the position of the decision in its Problem, as
+// used by Problem.Get.
+func (d *Decision) Index() int {
+	return d.index
+}
+
+func (d *Decision) Groups() []*Group {
+	return d.groups
 }
 
 func (g *Group) Decisions() []*Decision {
 	return g.decisions
 }
 
+// Name returns the name given to the group by AddNamedGroup, if any.
+func (g *Group) Name() string {
+	return g.name
+}
+
+func (g *Group) Constraint() ConstraintChecker {
+	return g.constraint
+}
+
 // A ConstraintChecker is any object that can be used to validate a
-// constraint over a group.
+// constraint over a group. A parallel solve shares each checker
+// between the workers' copies of the problem, so Apply may be called
+// concurrently and should not modify the checker.
 type ConstraintChecker interface {
 	Init(all []*Decision, size int)
 	Apply(all, dirty []*Decision) bool
 }
 
+// A GroupChecker is a ConstraintChecker that only makes sense for
+// some groups, such as one whose parameters describe each value.
+// CheckGroup reports why a group of groupSize decisions, each with
+// valueSize values, does not suit it.
+type GroupChecker interface {
+	CheckGroup(groupSize, valueSize int) error
+}
+
+// checkGroup returns the error the constraint of a group would
+// report about it, if any.
+func checkGroup(c ConstraintChecker, groupSize, valueSize int) error {
+	if gc, ok := c.(GroupChecker); ok {
+		return gc.CheckGroup(groupSize, valueSize)
+	}
+	return nil
+}
+
+// A CostedConstraint is a ConstraintChecker that declares how
+// expensive its Apply is, as one of the Cost constants. Propagation
+// runs cheaper constraints to a fixpoint before applying dearer
+// ones. Constraints that do not declare a cost count as CostCheap.
+type CostedConstraint interface {
+	Cost() int
+}
+
+const (
+	// CostCheap suits constraints that do a little work per changed
+	// decision, such as uniqueness.
+	CostCheap = iota
+	// CostLinear suits constraints that look over their whole group,
+	// such as counts and sums.
+	CostLinear
+	// CostExpensive suits constraints that search or look beyond
+	// their group, such as word lists and loops.
+	CostExpensive
+	numCosts
+)
+
+// costOf returns the cost declared by c, clamped to the Cost
+// constants.
+func costOf(c ConstraintChecker) int {
+	cc, ok := c.(CostedConstraint)
+	if !ok {
+		return CostCheap
+	}
+	cost := cc.Cost()
+	if cost < CostCheap {
+		return CostCheap
+	}
+	if cost >= numCosts {
+		return numCosts - 1
+	}
+	return cost
+}
+
 // A DecisionTracker is an interface that is called during a solve
 // whenever a decision is made. Its primary purpose at this time is to
 // allow the caller to track progress and count decisions.
@@ -112,6 +223,21 @@ type DecisionTracker interface {
 	CaptureDecision(p *Problem)
 }
 
+// A BacktrackTracker can optionally be implemented by a
+// DecisionTracker to be told whenever a decision turns out to be
+// wrong and is undone.
+type BacktrackTracker interface {
+	CaptureBacktrack(p *Problem)
+}
+
+// A PropagationTracker can optionally be implemented by a
+// DecisionTracker to be told about every round of constraint
+// propagation. Parallel searches only report rounds made before the
+// search is split.
+type PropagationTracker interface {
+	CapturePropagation(p *Problem)
+}
+
 // A SolutionTracker is an interface that is called during a solve
 // whenever a solution is found. It can be use to print, record,
 // summarize, or sample solutions.
@@ -125,13 +251,74 @@ type Decider interface {
 	Decide(d []*Decision, g []*Group) *Decision
 }
 
+// A ValueOrderer decides the order in which to try the possible
+// values of a decision. It is passed the possible values in ascending
+// order and may reorder them in place. A parallel solve calls it from
+// several goroutines at once.
+type ValueOrderer interface {
+	Order(d *Decision, values []int) []int
+}
+
 type Settings struct {
 	DecisionTracker
 	SolutionTracker
 	Decider
+	// ValueOrderer defaults to trying values in ascending order.
+	ValueOrderer
+	// Trace, if set, records every deduction made while solving.
+	// Parallel searches only record deductions made before the
+	// search is split.
+	Trace *Trace
+	// Stats, if set, is filled in with statistics about the solve,
+	// or about all of them together for calls such as Sample that
+	// solve several times.
+	Stats *Stats
+	// FindAll keeps searching after a solution is found, so that
+	// every solution is passed to the SolutionTracker.
+	FindAll bool
+	// MaxSolutions stops a FindAll search once this many solutions
+	// have been found. Zero means no limit.
+	MaxSolutions int
+	// MaxDecisions abandons the search after this many decisions.
+	// Zero means no limit.
+	MaxDecisions int
+	// Probe strengthens propagation by tentatively trying every
+	// possible value of every undecided decision and removing those
+	// that lead straight to a conflict. This is much slower per
+	// decision but can avoid a lot of guessing.
+	Probe bool
+	// Backjump makes the search track which earlier guesses caused
+	// each conflict, so that it can jump straight back past guesses
+	// that played no part instead of trying their other values.
+	Backjump bool
+	// LearnNogoods additionally remembers each combination of
+	// guesses found to conflict, and avoids it in the rest of the
+	// search. It has no effect without Backjump.
+	LearnNogoods bool
+	// Restarts, if set, abandons each run of the search after the
+	// number of backtracks the strategy allows and starts again,
+	// breaking ties between decisions and between values at
+	// random. Restarts are not used when counting solutions.
+	Restarts RestartStrategy
+	// Seed seeds the random choices made when restarting, so that
+	// runs can be reproduced.
+	Seed int64
+	// Workers is the number of goroutines to search with. Values
+	// above one split the search tree between workers, which each
+	// search their own copy of the problem. Trackers are never
+	// called concurrently, and are always passed the original
+	// problem for solutions. Which solution is found first is not
+	// deterministic.
+	Workers int
 }
 
-type undoRestricts []int
+// A trailEntry records a single restriction so that it can be
+// undone, along with the decision's previous reason.
+type trailEntry struct {
+	d      *Decision
+	value  int
+	reason levelSet
+}
 
 // A Problem captures the decisions and groups, as well as any
 // ephemeral state used to solve the problem.
@@ -139,9 +326,39 @@ type Problem struct {
 	valueSize int
 	decisions []*Decision
 	groups    []*Group
-	undoStack []map[*Decision]undoRestricts
-	dirty     map[*Decision]bool
-	conflict  bool
+	// trail holds every restriction made since the first snapshot,
+	// and marks holds the length of the trail at each snapshot.
+	trail []trailEntry
+	marks []int
+	// base is the number of snapshots when the current solve began,
+	// which Depth does not count as guesses.
+	base  int
+	dirty []*Decision
+	// queues holds the groups waiting to be applied, by cost, and
+	// round the groups applied in the last round.
+	queues   [numCosts][]*Group
+	round    []*Group
+	conflict bool
+	// trace records deductions while cause, the group whose
+	// constraint is being applied, is set.
+	trace *Trace
+	cause *Group
+	// rounds is told about each round of propagation.
+	rounds PropagationTracker
+	// stats, if set, counts the work done on this Problem.
+	stats *Stats
+	// When reasons is set, each restriction records the levels
+	// responsible for it in the decision's reason. They come from
+	// the cause's decisions, or from explain if there is no cause.
+	// conflictReason holds the levels responsible for a conflict.
+	reasons        bool
+	causeReason    levelSet
+	causeKnown     bool
+	explain        levelSet
+	conflictReason levelSet
+	// conflictGroup is the group being checked when the last
+	// conflict was found.
+	conflictGroup *Group
 }
 
 func (p *Problem) Size() int {
@@ -152,28 +369,76 @@ func (p *Problem) ValueSize() int {
 	return p.valueSize
 }
 
+func (p *Problem) Groups() []*Group {
+	return p.groups
+}
+
+// Depth returns the number of guesses currently in effect during a
+// search. Checkpoints taken before the search do not count.
+func (p *Problem) Depth() int {
+	return len(p.marks) - p.base
+}
+
 func (p *Problem) check() bool {
+	if p.stats != nil {
+		start := time.Now()
+		defer func() { p.stats.Propagation += time.Since(start) }()
+	}
 	if p.conflict {
-		p.dirty = map[*Decision]bool{}
+		p.clearDirty()
 		p.conflict = false
 		return false
 	}
-	for len(p.dirty) > 0 {
-		groups := map[*Group][]*Decision{}
-		for d := range p.dirty {
+	for {
+		for _, d := range p.dirty {
+			d.dirty = false
 			for _, g := range d.groups {
-				groups[g] = append(groups[g], d)
+				if len(g.pending) == 0 {
+					p.queues[g.cost] = append(p.queues[g.cost], g)
+				}
+				g.pending = append(g.pending, d)
 			}
 		}
-		p.dirty = map[*Decision]bool{}
-		for g, dirty := range groups {
-			if !g.constraint.Apply(g.decisions, dirty) {
-				p.conflict = true
+		p.dirty = p.dirty[:0]
+		// Each round applies the queued groups of the lowest cost,
+		// so that cheaper constraints reach a fixpoint before any
+		// dearer one is applied.
+		var groups []*Group
+		for c := range p.queues {
+			if len(p.queues[c]) > 0 {
+				groups = p.queues[c]
+				p.queues[c] = p.round[:0]
 				break
 			}
 		}
+		if groups == nil {
+			break
+		}
+		if p.rounds != nil {
+			p.rounds.CapturePropagation(p)
+		}
+		for _, g := range groups {
+			p.cause = g
+			p.causeKnown = false
+			if !p.conflict {
+				if p.stats != nil {
+					p.stats.applies[reflect.TypeOf(g.constraint)]++
+				}
+				if !g.constraint.Apply(g.decisions, g.pending) {
+					var reason levelSet
+					if p.reasons {
+						reason = p.explanation()
+					}
+					p.setConflict(reason)
+				}
+			}
+			g.pending = g.pending[:0]
+		}
+		p.cause = nil
+		p.round = groups
 		if p.conflict {
-			p.dirty = map[*Decision]bool{}
+			p.clearDirty()
+			p.clearQueues()
 			p.conflict = false
 			return false
 		}
@@ -182,70 +447,592 @@ func (p *Problem) check() bool {
 	return true
 }
 
+// clearQueues drops the groups waiting to be applied.
+func (p *Problem) clearQueues() {
+	for c, groups := range p.queues {
+		for _, g := range groups {
+			g.pending = g.pending[:0]
+		}
+		p.queues[c] = groups[:0]
+	}
+}
+
+func (p *Problem) clearDirty() {
+	for _, d := range p.dirty {
+		d.dirty = false
+	}
+	p.dirty = p.dirty[:0]
+}
+
 func (p *Problem) snapshot() {
-	p.undoStack = append(p.undoStack, map[*Decision]undoRestricts{})
+	p.marks = append(p.marks, len(p.trail))
 }
 
 func (p *Problem) undo() {
-	i := len(p.undoStack) - 1
-	m := p.undoStack[i]
-	p.undoStack = p.undoStack[:i]
-	for d, item := range m {
-		d.undo(item)
+	i := len(p.marks) - 1
+	mark := p.marks[i]
+	p.marks = p.marks[:i]
+	for j := len(p.trail) - 1; j >= mark; j-- {
+		e := p.trail[j]
+		e.d.domain.add(e.value)
+		e.d.reason = e.reason
+	}
+	p.trail = p.trail[:mark]
+}
+
+// Checkpoint saves the current state of the Problem, so that later
+// restrictions can be undone by passing the result to Rollback.
+// Checkpoints nest: rolling back to one discards any taken after it.
+func (p *Problem) Checkpoint() int {
+	p.snapshot()
+	return len(p.marks) - 1
+}
+
+// Rollback returns the Problem to its state when checkpoint was
+// taken. It returns an error, and changes nothing, if checkpoint was
+// not returned by Checkpoint or has already been rolled back past.
+func (p *Problem) Rollback(checkpoint int) error {
+	if checkpoint < 0 || checkpoint >= len(p.marks) {
+		return fmt.Errorf("csp: no checkpoint %d to roll back to", checkpoint)
+	}
+	p.undoTo(checkpoint)
+	p.conflict = false
+	return nil
+}
+
+// Clone returns a copy of the Problem in its current state, which
+// becomes the copy's initial state. The copy shares the original's
+// ConstraintCheckers, which must be safe to share as for a parallel
+// solve.
+func (p *Problem) Clone() *Problem {
+	q := &Problem{valueSize: p.valueSize}
+	for _, d := range p.decisions {
+		q.decisions = append(q.decisions, &Decision{
+			domain: d.domain.clone(),
+			index:  d.index,
+			p:      q,
+			dirty:  d.dirty,
+		})
+		if d.dirty {
+			// Carry over restrictions not yet propagated.
+			q.dirty = append(q.dirty, q.decisions[d.index])
+		}
+	}
+	for _, g := range p.groups {
+		g2 := &Group{name: g.name, index: g.index, constraint: g.constraint, cost: g.cost}
+		for _, d := range g.decisions {
+			d2 := q.decisions[d.index]
+			g2.decisions = append(g2.decisions, d2)
+			d2.groups = append(d2.groups, g2)
+		}
+		q.groups = append(q.groups, g2)
+	}
+	return q
+}
+
+// undoTo unwinds snapshots until only n remain.
+func (p *Problem) undoTo(n int) {
+	for len(p.marks) > n {
+		p.undo()
 	}
 }
 
 func (p *Problem) setDirty(d *Decision, restrict int) {
-	if i := len(p.undoStack) - 1; i >= 0 {
-		p.undoStack[i][d] = append(p.undoStack[i][d], restrict)
+	reason := d.reason
+	if p.reasons {
+		d.reason = d.reason.union(p.explanation())
+	}
+	// Restrictions made before the first snapshot are permanent.
+	if len(p.marks) > 0 {
+		p.trail = append(p.trail, trailEntry{d, restrict, reason})
+	}
+	if !d.dirty {
+		d.dirty = true
+		p.dirty = append(p.dirty, d)
+	}
+	if p.trace != nil && p.cause != nil {
+		p.trace.record(p, d, restrict, p.cause)
 	}
-	p.dirty[d] = true
 }
 
-func (p *Problem) setConflict() {
+// setConflict records a conflict, along with the levels responsible
+// for it if this is the first since the last check.
+func (p *Problem) setConflict(reason levelSet) {
+	if !p.conflict && p.reasons {
+		p.conflictReason = reason
+	}
+	if !p.conflict {
+		p.conflictGroup = p.cause
+	}
 	p.conflict = true
 }
 
 // Attempts to solve the Problem, and returns true if a solution
-// exists.
+// exists. If s.FindAll is set the search continues past the first
+// solution; see Count.
 func (p *Problem) Solve(s Settings) bool {
-	if !p.check() {
-		return false
+	ok, _ := p.SolveContext(context.Background(), s)
+	return ok
+}
+
+// SolveContext is like Solve, but gives up when ctx is done or
+// s.MaxDecisions is reached. In that case it returns ctx.Err() or
+// ErrBudgetExceeded and the Problem is returned to its state before
+// the search. A nil error with a false result means the Problem has
+// no solution.
+func (p *Problem) SolveContext(ctx context.Context, s Settings) (bool, error) {
+	n, err := p.solve(ctx, s, nil)
+	return n > 0, err
+}
+
+// Count searches for every solution to the Problem and returns how
+// many were found, stopping early once s.MaxSolutions is reached.
+// Setting MaxSolutions to 2 is enough to check that a puzzle has a
+// unique solution. If the search runs to completion the Problem is
+// left in its initial state; otherwise it holds the last solution.
+func (p *Problem) Count(s Settings) int {
+	n, _ := p.CountContext(context.Background(), s)
+	return n
+}
+
+// CountContext is like Count, but gives up as described in
+// SolveContext. The count returned with an error is the number of
+// solutions found before giving up.
+func (p *Problem) CountContext(ctx context.Context, s Settings) (int, error) {
+	s.FindAll = true
+	return p.solve(ctx, s, nil)
+}
+
+func (p *Problem) solve(ctx context.Context, s Settings, o Objective) (int, error) {
+	defer p.track(s)()
+	if !p.propagate(s) {
+		return 0, nil
+	}
+	sr := &search{Settings: s, ctx: ctx, p: p, base: len(p.marks), objective: o}
+	if s.Workers > 1 {
+		sr.parallelSolve()
+	} else {
+		p.reasons = s.Backjump
+		defer func() { p.reasons = false }()
+		sv := solver{search: sr, p: p, rng: sr.newRand(0)}
+		sv.run()
+	}
+	if sr.err != nil || !sr.finished {
+		p.undoTo(sr.base)
+	}
+	if o != nil && sr.solutions > 0 {
+		sr.restoreBest()
+	}
+	if s.Stats != nil {
+		s.Stats.Decisions = sr.decisions
 	}
-	return p.recSolve(s)
+	return sr.solutions, sr.err
 }
 
-func (p *Problem) recSolve(s Settings) bool {
-	var ds []*Decision
-	for _, d := range p.decisions {
-		if d.Value() == -1 {
-			ds = append(ds, d)
+// A search holds the state of a single call to solve, shared by
+// every solver taking part.
+type search struct {
+	Settings
+	ctx context.Context
+	// p is the Problem being solved, and base is its number of
+	// snapshots when the search started.
+	p    *Problem
+	base int
+	// mu guards the fields below, and serializes calls to the
+	// trackers.
+	mu        sync.Mutex
+	solutions int
+	decisions int
+	finished  bool
+	err       error
+	// When optimizing, best is the score of the best solution found
+	// so far, whose values are kept in bestValues.
+	objective  Objective
+	best       int
+	bestValues []int
+}
+
+// values returns the possible values of d in the order they should
+// be tried, breaking ties at random if rng is set.
+func (sr *search) values(d *Decision, rng *rand.Rand) []int {
+	values := d.domain.values(nil)
+	if rng != nil {
+		// Shuffling first breaks any ties left by the orderer at
+		// random.
+		rng.Shuffle(len(values), func(i, j int) {
+			values[i], values[j] = values[j], values[i]
+		})
+	}
+	if sr.ValueOrderer != nil {
+		values = sr.Order(d, values)
+	}
+	return values
+}
+
+// newRand returns the random source for the i-th solver, or nil if
+// the search is not randomized.
+func (sr *search) newRand(i int) *rand.Rand {
+	if sr.Restarts == nil || sr.FindAll {
+		return nil
+	}
+	return rand.New(rand.NewSource(sr.Seed + int64(i)))
+}
+
+// solutionCount returns the number of solutions found so far.
+func (sr *search) solutionCount() int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.solutions
+}
+
+// stopped reports whether the search has finished or given up.
+func (sr *search) stopped() bool {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.finished || sr.err != nil
+}
+
+// A solver searches a single Problem, which is either the Problem
+// being solved or a parallel worker's clone of it.
+type solver struct {
+	*search
+	p *Problem
+	// When backjumping, failure holds the levels responsible for the
+	// last failed subtree, guesses holds the assignments made at
+	// each level, and nogoods holds the conflicts learned so far.
+	failure levelSet
+	guesses []guess
+	nogoods [][]assignment
+	// When restarting, rng breaks ties, groups holds the groups in
+	// a random order for the Decider, and the current run restarts
+	// once backtracks reaches limit.
+	rng        *rand.Rand
+	groups     []*Group
+	backtracks int
+	limit      int
+	restart    bool
+}
+
+// foundSolution records the solution held by the solver's Problem
+// and reports whether the search should stop.
+func (sv *solver) foundSolution() bool {
+	sr := sv.search
+	var score int
+	if sr.objective != nil {
+		score = sr.objective.Value(sv.p.decisions)
+		// Later solutions must beat this one, which depends on
+		// every guess so far.
+		sv.failure = allLevels(len(sv.p.marks))
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.finished || sr.err != nil {
+		return true
+	}
+	if sr.objective != nil && !sr.improve(sv.p, score) {
+		return false
+	}
+	sr.solutions++
+	if sv.p != sr.p {
+		sr.p.undoTo(sr.base)
+		sr.p.snapshot()
+		for i, d := range sv.p.decisions {
+			sr.p.decisions[i].RestrictTo(d.Value())
 		}
+		sr.p.clearDirty()
 	}
-	if len(ds) == 0 {
-		if s.SolutionTracker != nil {
-			s.CaptureSolution(p)
+	if sr.SolutionTracker != nil {
+		sr.CaptureSolution(sr.p)
+	}
+	// An optimizing search goes on to look for better solutions.
+	more := sr.FindAll || sr.objective != nil
+	sr.finished = !more || (sr.MaxSolutions > 0 && sr.solutions >= sr.MaxSolutions)
+	return sr.finished
+}
+
+// backtrack reports that the last decision was undone.
+func (sv *solver) backtrack() {
+	sr := sv.search
+	if sv.p.stats != nil {
+		sv.p.stats.Backtracks++
+	}
+	if bt, ok := sr.DecisionTracker.(BacktrackTracker); ok {
+		sr.mu.Lock()
+		defer sr.mu.Unlock()
+		bt.CaptureBacktrack(sv.p)
+	}
+}
+
+// decide counts a decision made on p and reports whether the search
+// should give up instead of making it.
+func (sr *search) decide(p *Problem) bool {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.finished || sr.err != nil {
+		return false
+	}
+	if sr.MaxDecisions > 0 && sr.decisions >= sr.MaxDecisions {
+		sr.err = ErrBudgetExceeded
+		return false
+	}
+	// Polling the context is comparatively expensive, so only do it
+	// every so often.
+	if sr.decisions%64 == 0 {
+		if err := sr.ctx.Err(); err != nil {
+			sr.err = err
+			return false
 		}
-		return true
 	}
-	d := s.Decide(ds, p.groups)
-	for i := 0; i < p.valueSize; i++ {
-		if !d.Possible(i) {
-			continue
+	sr.decisions++
+	if sr.DecisionTracker != nil {
+		sr.CaptureDecision(p)
+	}
+	return true
+}
+
+// run searches from the current state of the solver's Problem,
+// restarting as directed by s.Restarts.
+func (sv *solver) run() {
+	if sv.Restarts == nil || sv.FindAll {
+		sv.recSolve()
+		return
+	}
+	base, guesses := len(sv.p.marks), len(sv.guesses)
+	for i := 0; ; i++ {
+		sv.limit, sv.backtracks, sv.restart = sv.Restarts.Limit(i), 0, false
+		sv.groups = append(sv.groups[:0], sv.p.groups...)
+		sv.rng.Shuffle(len(sv.groups), func(i, j int) {
+			sv.groups[i], sv.groups[j] = sv.groups[j], sv.groups[i]
+		})
+		if !sv.recSolve() || !sv.restart {
+			return
 		}
-		if s.DecisionTracker != nil {
-			s.CaptureDecision(p)
+		sv.p.undoTo(base)
+		sv.guesses = sv.guesses[:guesses]
+	}
+}
+
+// restartDue counts a backtrack and reports whether the current run
+// should be abandoned.
+func (sv *solver) restartDue() bool {
+	sv.backtracks++
+	if sv.limit > 0 && sv.backtracks >= sv.limit {
+		sv.restart = true
+	}
+	return sv.restart
+}
+
+// decideNext chooses the next decision to make, breaking ties at
+// random when restarting.
+func (sv *solver) decideNext(ds []*Decision) *Decision {
+	if sv.rng == nil {
+		return sv.Decide(ds, sv.p.groups)
+	}
+	sv.rng.Shuffle(len(ds), func(i, j int) {
+		ds[i], ds[j] = ds[j], ds[i]
+	})
+	return sv.Decide(ds, sv.groups)
+}
+
+// recSolve returns true if the search is finished, either because
+// enough solutions were found or because it gave up.
+func (sv *solver) recSolve() bool {
+	p := sv.p
+	if !sv.withinBound() {
+		sv.failure = allLevels(len(p.marks))
+		return false
+	}
+	ds := p.undecided()
+	if len(ds) == 0 {
+		return sv.foundSolution()
+	}
+	d := sv.decideNext(ds)
+	level := len(p.marks) + 1
+	// Values removed before guessing count against every guess.
+	conflict := d.reason
+	for _, v := range sv.values(d, sv.rng) {
+		if !sv.decide(p) {
+			return true
 		}
+		solutions := sv.solutionCount()
 		p.snapshot()
-		d.RestrictTo(i)
-		if p.check() && p.recSolve(s) {
-			return true
+		if p.stats != nil && p.Depth() > p.stats.MaxDepth {
+			p.stats.MaxDepth = p.Depth()
+		}
+		if p.trace != nil {
+			p.trace.recordGuess(p, d, v)
+		}
+		d.RestrictTo(v)
+		if sv.Backjump {
+			sv.guesses = append(sv.guesses, guess{level, []assignment{{d.index, v}}})
+		}
+		if p.propagate(sv.Settings) && sv.applyNogoods() {
+			if sv.recSolve() {
+				return true
+			}
+		} else {
+			sv.failure = p.conflictReason
 		}
 		p.undo()
+		sv.backtrack()
+		if sv.restartDue() {
+			return true
+		}
+		if !sv.Backjump {
+			continue
+		}
+		sv.guesses = sv.guesses[:len(sv.guesses)-1]
+		failure := sv.failure
+		if sv.solutionCount() != solutions {
+			// Solutions were found below, so none of the
+			// guesses so far can be skipped.
+			failure = allLevels(level)
+		}
+		if !failure.has(level) {
+			// This guess played no part in the failure, so
+			// neither will its other values.
+			return false
+		}
+		conflict = conflict.union(failure.without(level))
+	}
+	if sv.Backjump {
+		sv.failure = conflict
+		sv.learn(conflict)
 	}
 	return false
 }
 
+// Propagate applies every constraint repeatedly until no more can be
+// deduced, without ever guessing, and leaves the Problem in the
+// resulting state. It returns the decisions that remain undecided,
+// or false if the constraints conflict. Of the settings, only Trace,
+// Probe and a DecisionTracker implementing PropagationTracker are
+// used.
+func (p *Problem) Propagate(s Settings) ([]*Decision, bool) {
+	defer p.track(s)()
+	// Constraints normally only look at decisions that have changed,
+	// so start by treating every decision as changed.
+	for _, d := range p.decisions {
+		if !d.dirty {
+			d.dirty = true
+			p.dirty = append(p.dirty, d)
+		}
+	}
+	if !p.propagate(s) {
+		return nil, false
+	}
+	return p.undecided(), true
+}
+
+// propagate runs check, followed by probing if s asks for it.
+func (p *Problem) propagate(s Settings) bool {
+	return p.check() && (!s.Probe || p.probe())
+}
+
+// explanation returns the levels responsible for a restriction made
+// now: those behind the cause's decisions if a constraint is being
+// applied, or else explain, or else the current level alone.
+func (p *Problem) explanation() levelSet {
+	if p.cause != nil {
+		// A constraint only restricts its own decisions, so the
+		// levels behind them do not change while it is applied.
+		if !p.causeKnown {
+			p.causeReason = nil
+			for _, d := range p.cause.decisions {
+				p.causeReason = p.causeReason.union(d.reason)
+			}
+			p.causeKnown = true
+		}
+		return p.causeReason
+	}
+	if p.explain != nil {
+		return p.explain
+	}
+	return levelSet(nil).with(len(p.marks))
+}
+
+// probe tentatively tries each possible value of each undecided
+// decision, and removes those values that conflict after propagation,
+// until there is nothing left to remove. It returns false on a
+// conflict.
+func (p *Problem) probe() bool {
+	trace := p.trace
+	defer func() { p.trace = trace }()
+	for changed := true; changed; {
+		changed = false
+		for _, d := range p.decisions {
+			if d.domain.count < 2 {
+				continue
+			}
+			for _, v := range d.domain.values(nil) {
+				if !d.Possible(v) {
+					continue
+				}
+				// Deductions made while trying a value are
+				// always undone, so leave them out of the trace.
+				p.trace = nil
+				p.snapshot()
+				d.RestrictTo(v)
+				ok := p.check()
+				p.undo()
+				p.trace = trace
+				if ok {
+					continue
+				}
+				if p.trace != nil {
+					p.trace.recordProbe(p, d, v)
+				}
+				// Probing may depend on any earlier guess.
+				p.explain = allLevels(len(p.marks))
+				d.Restrict(v)
+				p.explain = nil
+				if !p.check() {
+					return false
+				}
+				changed = true
+			}
+		}
+	}
+	return true
+}
+
+// track installs the trace and propagation tracker from s, and
+// returns a function that removes them again. Neither is supported by
+// the workers of a parallel search, which search clones of p.
+func (p *Problem) track(s Settings) func() {
+	p.trace = s.Trace
+	p.rounds, _ = s.DecisionTracker.(PropagationTracker)
+	base := p.base
+	p.base = len(p.marks)
+	start := time.Now()
+	if s.Stats != nil {
+		*s.Stats = *newStats()
+		p.stats = s.Stats
+	}
+	return func() {
+		p.trace = nil
+		p.rounds = nil
+		p.base = base
+		if p.stats != nil {
+			p.stats.Wall = time.Since(start)
+			p.stats.finish()
+			p.stats = nil
+		}
+	}
+}
+
+// undecided returns the decisions that still have more than one
+// possibility.
+func (p *Problem) undecided() []*Decision {
+	var result []*Decision
+	for _, d := range p.decisions {
+		if d.Value() == -1 {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
 // Print the current state of the problem.
 func (p *Problem) Print() {
 	for i, d := range p.decisions {
@@ -254,18 +1041,107 @@ func (p *Problem) Print() {
 	fmt.Println("")
 }
 
-// Set the value for a given decision. Used to set the initial
-// configuration, for example the givens on a sudoku problem.
-func (p *Problem) Set(i int, val int) {
+// Set restricts decision i to val, for example to set the givens of
+// a sudoku. It only rules out the other values: a val that
+// contradicts the constraints is found by the next propagation, or
+// straight away by SetChecked. It returns a ConflictError if val has
+// already been ruled out, and on error the Problem is left as it was.
+func (p *Problem) Set(i int, val int) error {
+	if err := p.checkSet(i, val); err != nil {
+		return err
+	}
 	p.decisions[i].RestrictTo(val)
+	return nil
+}
+
+// SetChecked is like Set, but first propagates the change
+// tentatively, so that a val contradicting the constraints is
+// reported along with the group it breaks. The propagation itself is
+// left for the next solve, so that it shows up in any Trace. Each
+// call propagates the whole Problem, so to set many values prefer Set
+// and a single propagation, falling back to SetChecked to find the
+// values at fault.
+func (p *Problem) SetChecked(i int, val int) error {
+	if err := p.checkSet(i, val); err != nil {
+		return err
+	}
+	if p.conflict {
+		// Propagating would only find the earlier conflict.
+		return &ConflictError{Decision: i, Value: val, InConflict: true}
+	}
+	d := p.decisions[i]
+	dirty := append([]*Decision(nil), p.dirty...)
+	p.snapshot()
+	d.RestrictTo(val)
+	// A conflict left from before must not be blamed on this value.
+	p.conflictGroup = nil
+	ok := p.check()
+	p.undo()
+	for _, d2 := range dirty {
+		d2.dirty = true
+	}
+	p.dirty = append(p.dirty[:0], dirty...)
+	if !ok {
+		return &ConflictError{Decision: i, Value: val, Group: p.conflictGroup}
+	}
+	d.RestrictTo(val)
+	return nil
+}
+
+// checkSet reports whether decision i can be set to val.
+func (p *Problem) checkSet(i int, val int) error {
+	if i < 0 || i >= len(p.decisions) {
+		return fmt.Errorf("csp: no decision %d in a problem of size %d", i, len(p.decisions))
+	}
+	if val < 0 || val >= p.valueSize {
+		return fmt.Errorf("csp: value %d out of range for decision %d", val, i)
+	}
+	if !p.decisions[i].Possible(val) {
+		return &ConflictError{Decision: i, Value: val}
+	}
+	return nil
+}
+
+// A ConflictError reports a value given to Set or SetChecked that
+// contradicts the Problem's constraints.
+type ConflictError struct {
+	Decision, Value int
+	// Group is the group found to be broken, or nil if the value
+	// had already been ruled out or the Problem was already in
+	// conflict.
+	Group *Group
+	// InConflict is set if SetChecked found the Problem already in
+	// conflict, so that the value could not be checked.
+	InConflict bool
+}
+
+func (e *ConflictError) Error() string {
+	if e.InConflict {
+		return fmt.Sprintf("csp: cannot check decision %d as %d: the problem is already in conflict", e.Decision, e.Value)
+	}
+	if e.Group == nil {
+		return fmt.Sprintf("csp: decision %d cannot be %d: already ruled out", e.Decision, e.Value)
+	}
+	return fmt.Sprintf("csp: setting decision %d to %d contradicts %s", e.Decision, e.Value, e.Group.describe())
 }
 
 func (p *Problem) Get(i int) *Decision {
 	return p.decisions[i]
 }
 
+// AddGroup adds a group of the given decisions, held to constraint. It
+// panics if constraint is a GroupChecker that rejects the group.
 func (p *Problem) AddGroup(group []int, constraint ConstraintChecker) {
-	g := Group{constraint: constraint}
+	p.AddNamedGroup("", group, constraint)
+}
+
+// AddNamedGroup is like AddGroup, but names the group so that it can
+// be identified in traces.
+func (p *Problem) AddNamedGroup(name string, group []int, constraint ConstraintChecker) {
+	g := Group{name: name, index: len(p.groups), constraint: constraint, cost: costOf(constraint)}
+	if err := checkGroup(constraint, len(group), p.valueSize); err != nil {
+		panic(fmt.Sprintf("csp: %s: %v", g.describe(), err))
+	}
 	for _, d := range group {
 		g.decisions = append(g.decisions, p.decisions[d])
 		p.decisions[d].groups = append(p.decisions[d].groups, &g)
@@ -277,10 +1153,9 @@ func (p *Problem) AddGroup(group []int, constraint ConstraintChecker) {
 func NewProblem(size int, valueSize int) *Problem {
 	p := Problem{
 		valueSize: valueSize,
-		dirty:     map[*Decision]bool{},
 	}
 	for i := 0; i < size; i++ {
-		p.decisions = append(p.decisions, newDecision(valueSize, &p))
+		p.decisions = append(p.decisions, newDecision(valueSize, i, &p))
 	}
 	return &p
 }
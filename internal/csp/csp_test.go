@@ -1,9 +1,952 @@
 package csp
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 )
 
-func TestTest(t *testing.T) {
+// distinct is a minimal uniqueness constraint, so that the solver can
+// be tested without depending on the constraints package.
+type distinct struct{}
 
+func (c distinct) Init(all []*Decision, size int) {}
+func (c distinct) Apply(all, dirty []*Decision) bool {
+	for _, d := range dirty {
+		if v := d.Value(); v >= 0 {
+			for _, d2 := range all {
+				if d2 != d {
+					d2.Restrict(v)
+				}
+			}
+		}
+	}
+	return true
+}
+
+type first struct{}
+
+func (dec first) Decide(d []*Decision, g []*Group) *Decision {
+	return d[0]
+}
+
+// countingDecider is like first, but counts the calls to Decide.
+type countingDecider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (dec *countingDecider) Decide(d []*Decision, g []*Group) *Decision {
+	dec.mu.Lock()
+	defer dec.mu.Unlock()
+	dec.calls++
+	return d[0]
+}
+
+type solutionCounter struct {
+	count int
+}
+
+func (sc *solutionCounter) CaptureSolution(p *Problem) {
+	sc.count++
+}
+
+// newPermutations returns a problem whose solutions are the
+// permutations of n values.
+func newPermutations(n int) *Problem {
+	p := NewProblem(n, n)
+	var all []int
+	for i := 0; i < n; i++ {
+		all = append(all, i)
+	}
+	p.AddGroup(all, distinct{})
+	return p
+}
+
+func TestSolve(t *testing.T) {
+	p := newPermutations(3)
+	if !p.Solve(Settings{Decider: first{}}) {
+		t.Fatalf("failed to solve")
+	}
+	for i := 0; i < 3; i++ {
+		if got := p.Get(i).Value(); got != i {
+			t.Errorf("decision %d: got %d, want %d", i, got, i)
+		}
+	}
+}
+
+var countTests = []struct {
+	name         string
+	n            int
+	given        int
+	maxSolutions int
+	want         int
+}{
+	{name: "all permutations", n: 4, given: -1, want: 24},
+	{name: "limited", n: 4, given: -1, maxSolutions: 2, want: 2},
+	{name: "with given", n: 4, given: 2, want: 6},
+	{name: "limit above count", n: 3, given: -1, maxSolutions: 10, want: 6},
+}
+
+func TestCount(t *testing.T) {
+	for _, tt := range countTests {
+		p := newPermutations(tt.n)
+		if tt.given >= 0 {
+			p.Set(0, tt.given)
+		}
+		sc := &solutionCounter{}
+		got := p.Count(Settings{Decider: first{}, SolutionTracker: sc, MaxSolutions: tt.maxSolutions})
+		if got != tt.want {
+			t.Errorf("test: %s, got %d solutions, want %d", tt.name, got, tt.want)
+		}
+		if sc.count != got {
+			t.Errorf("test: %s, tracker saw %d solutions, want %d", tt.name, sc.count, got)
+		}
+	}
+}
+
+func TestCountNoSolution(t *testing.T) {
+	p := NewProblem(3, 2)
+	p.AddGroup([]int{0, 1, 2}, distinct{})
+	if got := p.Count(Settings{Decider: first{}}); got != 0 {
+		t.Errorf("got %d solutions, want 0", got)
+	}
+}
+
+func TestSolveContextGivesUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		s    Settings
+		want error
+	}{
+		{name: "budget", ctx: context.Background(), s: Settings{Decider: first{}, MaxDecisions: 10}, want: ErrBudgetExceeded},
+		{name: "cancelled", ctx: ctx, s: Settings{Decider: first{}}, want: context.Canceled},
+		{name: "parallel budget", ctx: context.Background(), s: Settings{Decider: first{}, MaxDecisions: 10, Workers: 3}, want: ErrBudgetExceeded},
+		{name: "parallel cancelled", ctx: ctx, s: Settings{Decider: first{}, Workers: 3}, want: context.Canceled},
+	}
+	for _, tt := range tests {
+		p := newPermutations(6)
+		cd := &countingDecider{}
+		tt.s.Decider = cd
+		n, err := p.CountContext(tt.ctx, tt.s)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("test: %s, got error %v, want %v", tt.name, err, tt.want)
+		}
+		// Each decision asks the Decider at most once, as may each
+		// worker before it gives up.
+		if limit := tt.s.MaxDecisions + tt.s.Workers + 1; cd.calls > limit {
+			t.Errorf("test: %s, asked the Decider %d times, want at most %d", tt.name, cd.calls, limit)
+		}
+		if n == 720 {
+			t.Errorf("test: %s, search was not cut short", tt.name)
+		}
+		for i := 0; i < p.Size(); i++ {
+			if p.Get(i).Count() != 6 {
+				t.Errorf("test: %s, decision %d was not restored", tt.name, i)
+			}
+		}
+	}
+}
+
+func TestSolveContextNoSolution(t *testing.T) {
+	p := NewProblem(3, 2)
+	p.AddGroup([]int{0, 1, 2}, distinct{})
+	ok, err := p.SolveContext(context.Background(), Settings{Decider: first{}, MaxDecisions: 100})
+	if ok || err != nil {
+		t.Errorf("got (%v, %v), want (false, nil)", ok, err)
+	}
+}
+
+func TestLargeDomain(t *testing.T) {
+	p := NewProblem(2, 130)
+	d := p.Get(0)
+	if d.Count() != 130 || !d.Possible(129) || d.Possible(130) {
+		t.Fatalf("bad initial domain: count %d", d.Count())
+	}
+	d.Restrict(5)
+	d.Restrict(100)
+	if d.Count() != 128 || d.Possible(5) || d.Possible(100) {
+		t.Errorf("restrict failed: count %d", d.Count())
+	}
+	p.snapshot()
+	d.RestrictTo(120)
+	if d.Value() != 120 {
+		t.Errorf("got value %d, want 120", d.Value())
+	}
+	p.Get(1).RestrictToEqual(d)
+	if p.Get(1).Value() != 120 {
+		t.Errorf("got value %d, want 120", p.Get(1).Value())
+	}
+	p.undo()
+	if d.Count() != 128 || p.Get(1).Count() != 130 || !d.Possible(64) {
+		t.Errorf("undo failed: counts %d, %d", d.Count(), p.Get(1).Count())
+	}
+}
+
+// checkPermutation reports an error unless p holds a permutation.
+type checkPermutation struct {
+	t     *testing.T
+	count int
+}
+
+func (c *checkPermutation) CaptureSolution(p *Problem) {
+	c.count++
+	seen := map[int]bool{}
+	for i := 0; i < p.Size(); i++ {
+		v := p.Get(i).Value()
+		if v < 0 || seen[v] {
+			c.t.Errorf("not a permutation: decision %d has value %d", i, v)
+		}
+		seen[v] = true
+	}
+}
+
+func TestParallel(t *testing.T) {
+	for _, workers := range []int{2, 3, 8} {
+		p := newPermutations(6)
+		c := &checkPermutation{t: t}
+		if got := p.Count(Settings{Decider: first{}, SolutionTracker: c, Workers: workers}); got != 720 {
+			t.Errorf("workers: %d, got %d solutions, want 720", workers, got)
+		}
+		if c.count != 720 {
+			t.Errorf("workers: %d, tracker saw %d solutions, want 720", workers, c.count)
+		}
+		if p.Get(0).Count() != 6 {
+			t.Errorf("workers: %d, problem was not restored", workers)
+		}
+
+		p = newPermutations(6)
+		if !p.Solve(Settings{Decider: first{}, SolutionTracker: &checkPermutation{t: t}, Workers: workers}) {
+			t.Errorf("workers: %d, failed to solve", workers)
+		}
+		for i := 0; i < p.Size(); i++ {
+			if p.Get(i).Value() < 0 {
+				t.Errorf("workers: %d, decision %d was left undecided", workers, i)
+			}
+		}
+
+		p = newPermutations(6)
+		if got := p.Count(Settings{Decider: first{}, Workers: workers, MaxSolutions: 5}); got != 5 {
+			t.Errorf("workers: %d, got %d limited solutions, want 5", workers, got)
+		}
+	}
+}
+
+type descending struct{}
+
+func (o descending) Order(d *Decision, values []int) []int {
+	for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+		values[i], values[j] = values[j], values[i]
+	}
+	return values
+}
+
+func TestValueOrderer(t *testing.T) {
+	p := newPermutations(3)
+	if !p.Solve(Settings{Decider: first{}, ValueOrderer: descending{}}) {
+		t.Fatalf("failed to solve")
+	}
+	for i := 0; i < 3; i++ {
+		if got := p.Get(i).Value(); got != 2-i {
+			t.Errorf("decision %d: got %d, want %d", i, got, 2-i)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	p := NewProblem(3, 3)
+	p.AddNamedGroup("all", []int{0, 1, 2}, distinct{})
+	p.Set(0, 0)
+	trace := &Trace{DecisionNames: []string{"a", "b", "c"}}
+	if !p.Solve(Settings{Decider: first{}, Trace: trace}) {
+		t.Fatalf("failed to solve")
+	}
+	want := []Deduction{
+		{Decision: 1, Value: 0, Group: 0, Name: "all", Constraint: "distinct"},
+		{Decision: 2, Value: 0, Group: 0, Name: "all", Constraint: "distinct"},
+		{Decision: 1, Value: 1, Guess: true, Group: -1, Depth: 1},
+		{Decision: 2, Value: 1, Group: 0, Name: "all", Constraint: "distinct", Depth: 1},
+	}
+	if len(trace.Deductions) != len(want) {
+		t.Fatalf("got deductions:\n%s\nwant %d", trace, len(want))
+	}
+	for i, got := range trace.Deductions {
+		if got != want[i] {
+			t.Errorf("deduction %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+	if got, want := trace.Describe(trace.Deductions[0]), "all distinct removed 0 from b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := trace.Describe(trace.Deductions[2]), "guessed 1 for b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	// Three decisions cannot take distinct values from two, but
+	// propagation alone cannot tell until something is decided.
+	p := NewProblem(3, 2)
+	p.AddGroup([]int{0, 1, 2}, distinct{})
+	if undecided, ok := p.Propagate(Settings{}); !ok || len(undecided) != 3 {
+		t.Errorf("without probing: got (%d undecided, %v), want (3, true)", len(undecided), ok)
+	}
+	if _, ok := p.Propagate(Settings{Probe: true}); ok {
+		t.Errorf("with probing: got no conflict")
+	}
+
+	// With a third value, probing finds no conflict, and the
+	// search still finds every solution.
+	p = newPermutations(3)
+	if undecided, ok := p.Propagate(Settings{Probe: true}); !ok || len(undecided) != 3 {
+		t.Errorf("permutations: got (%d undecided, %v), want (3, true)", len(undecided), ok)
+	}
+	if got := p.Count(Settings{Decider: first{}, Probe: true}); got != 6 {
+		t.Errorf("got %d solutions, want 6", got)
+	}
+}
+
+// forbid rules out a single pair of values for two decisions.
+type forbid struct {
+	a, b int
+}
+
+func (c forbid) Init(all []*Decision, size int) {}
+func (c forbid) CheckGroup(groupSize, valueSize int) error {
+	if groupSize != 2 {
+		return fmt.Errorf("forbid holds between 2 decisions, not %d", groupSize)
+	}
+	return nil
+}
+func (c forbid) Apply(all, dirty []*Decision) bool {
+	if all[0].Value() == c.a {
+		all[1].Restrict(c.b)
+	}
+	if all[1].Value() == c.b {
+		all[0].Restrict(c.a)
+	}
+	return true
+}
+
+// newRandomProblem returns a problem with random pairwise
+// restrictions, and a few uniqueness groups.
+func newRandomProblem(rng *rand.Rand) *Problem {
+	n, values := 10, 3
+	p := NewProblem(n, values)
+	for i := 0; i < 80; i++ {
+		x, y := rng.Intn(n), rng.Intn(n)
+		if x != y {
+			p.AddGroup([]int{x, y}, forbid{rng.Intn(values), rng.Intn(values)})
+		}
+	}
+	for i := 0; i < 2; i++ {
+		x, y := rng.Intn(n), rng.Intn(n)
+		if x != y {
+			p.AddGroup([]int{x, y}, distinct{})
+		}
+	}
+	return p
+}
+
+type decisionCounter struct {
+	count int
+}
+
+func (dc *decisionCounter) CaptureDecision(p *Problem) {
+	dc.count++
+}
+
+func TestBackjump(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		seed := rng.Int63()
+		want := newRandomProblem(rand.New(rand.NewSource(seed))).Count(Settings{Decider: first{}})
+		for _, s := range []Settings{
+			{Decider: first{}, Backjump: true},
+			{Decider: first{}, Backjump: true, LearnNogoods: true},
+			{Decider: first{}, Backjump: true, LearnNogoods: true, Probe: true},
+			{Decider: first{}, Backjump: true, LearnNogoods: true, Workers: 3},
+		} {
+			p := newRandomProblem(rand.New(rand.NewSource(seed)))
+			if got := p.Count(s); got != want {
+				t.Errorf("seed %d, settings %+v: got %d solutions, want %d", seed, s, got, want)
+			}
+			p = newRandomProblem(rand.New(rand.NewSource(seed)))
+			if got := p.Solve(s); got != (want > 0) {
+				t.Errorf("seed %d, settings %+v: solve returned %v with %d solutions", seed, s, got, want)
+			}
+		}
+	}
+}
+
+func TestBackjumpSkipsIrrelevantGuesses(t *testing.T) {
+	// Decisions 0 and 1 are unconstrained, and decisions 2 through 4
+	// cannot be distinct, which chronological backtracking only
+	// finds out again for every combination of the first two.
+	newProblem := func() *Problem {
+		p := NewProblem(5, 5)
+		p.AddGroup([]int{2, 3, 4}, distinct{})
+		for _, i := range []int{2, 3, 4} {
+			p.Get(i).Restrict(2)
+			p.Get(i).Restrict(3)
+			p.Get(i).Restrict(4)
+		}
+		return p
+	}
+	chronological := &decisionCounter{}
+	if newProblem().Solve(Settings{Decider: first{}, DecisionTracker: chronological}) {
+		t.Fatalf("solved an impossible problem")
+	}
+	backjump := &decisionCounter{}
+	if newProblem().Solve(Settings{Decider: first{}, DecisionTracker: backjump, Backjump: true}) {
+		t.Fatalf("solved an impossible problem with backjumping")
+	}
+	if backjump.count >= chronological.count {
+		t.Errorf("backjumping made %d decisions, chronological made %d", backjump.count, chronological.count)
+	}
+}
+
+func TestLuby(t *testing.T) {
+	want := []int{1, 1, 2, 1, 1, 2, 4, 1, 1, 2, 1, 1, 2, 4, 8, 1}
+	r := LubyRestarts(3)
+	for i, w := range want {
+		if got := r.Limit(i); got != 3*w {
+			t.Errorf("run %d: got %d, want %d", i, got, 3*w)
+		}
+	}
+}
+
+func TestGeometric(t *testing.T) {
+	want := []int{2, 3, 4, 6, 10}
+	r := GeometricRestarts(2, 1.5)
+	for i, w := range want {
+		if got := r.Limit(i); got != w {
+			t.Errorf("run %d: got %d, want %d", i, got, w)
+		}
+	}
+	if got := r.Limit(100); got != 0 {
+		t.Errorf("run 100: got %d, want 0", got)
+	}
+	for _, factor := range []float64{1, 0.5, 0, -2, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("factor %v: did not panic", factor)
+				}
+			}()
+			GeometricRestarts(1, factor)
+		}()
+	}
+}
+
+// satisfied reports whether every decision in p is decided and every
+// group's constraint holds.
+func satisfied(p *Problem) bool {
+	if len(p.undecided()) > 0 {
+		return false
+	}
+	for _, g := range p.groups {
+		if !g.constraint.Apply(g.decisions, g.decisions) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRestarts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		seed := rng.Int63()
+		want := newRandomProblem(rand.New(rand.NewSource(seed))).Count(Settings{Decider: first{}}) > 0
+		for _, s := range []Settings{
+			{Decider: first{}, Restarts: LubyRestarts(1), Seed: seed},
+			{Decider: first{}, Restarts: GeometricRestarts(1, 1.5), Seed: seed},
+			{Decider: first{}, Restarts: LubyRestarts(1), Seed: seed, Backjump: true, LearnNogoods: true},
+			{Decider: first{}, Restarts: LubyRestarts(1), Seed: seed, Workers: 3},
+		} {
+			p := newRandomProblem(rand.New(rand.NewSource(seed)))
+			if got := p.Solve(s); got != want {
+				t.Errorf("seed %d, settings %+v: got %v, want %v", seed, s, got, want)
+			} else if got && !satisfied(p) {
+				t.Errorf("seed %d, settings %+v: solution breaks a constraint", seed, s)
+			}
+		}
+	}
+}
+
+func TestRestartsReproducible(t *testing.T) {
+	solve := func(seed int64) ([]int, int) {
+		p := newPermutations(8)
+		dc := &decisionCounter{}
+		if !p.Solve(Settings{Decider: first{}, DecisionTracker: dc, Restarts: LubyRestarts(1), Seed: seed}) {
+			t.Fatalf("seed %d: no solution", seed)
+		}
+		var result []int
+		for i := 0; i < p.Size(); i++ {
+			result = append(result, p.Get(i).Value())
+		}
+		return result, dc.count
+	}
+	distinctSolutions := map[string]bool{}
+	for seed := int64(0); seed < 10; seed++ {
+		want, wantCount := solve(seed)
+		got, gotCount := solve(seed)
+		if fmt.Sprint(got) != fmt.Sprint(want) || gotCount != wantCount {
+			t.Errorf("seed %d: got %v after %d decisions, then %v after %d", seed, want, wantCount, got, gotCount)
+		}
+		distinctSolutions[fmt.Sprint(got)] = true
+	}
+	if len(distinctSolutions) < 2 {
+		t.Errorf("every seed gave the same solution %v", distinctSolutions)
+	}
+}
+
+// scoreTracker records the score of every solution it is passed.
+type scoreTracker struct {
+	o      Objective
+	scores []int
+}
+
+func (st *scoreTracker) CaptureSolution(p *Problem) {
+	st.scores = append(st.scores, st.o.Value(p.decisions))
+}
+
+func TestOptimize(t *testing.T) {
+	all := []int{0, 2, 3, 5, 7, 8, 9}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		seed := rng.Int63()
+		for _, o := range []Objective{MinimizeSum(all), MaximizeSum(all)} {
+			st := &scoreTracker{o: o}
+			newRandomProblem(rand.New(rand.NewSource(seed))).Count(Settings{Decider: first{}, SolutionTracker: st})
+			want := 0
+			for j, score := range st.scores {
+				if j == 0 || score < want {
+					want = score
+				}
+			}
+			for _, s := range []Settings{
+				{Decider: first{}},
+				{Decider: first{}, Backjump: true, LearnNogoods: true},
+				{Decider: first{}, Restarts: LubyRestarts(1), Seed: seed},
+				{Decider: first{}, Workers: 3},
+			} {
+				p := newRandomProblem(rand.New(rand.NewSource(seed)))
+				improving := &scoreTracker{o: o}
+				s.SolutionTracker = improving
+				if got := p.Optimize(o, s); got != (len(st.scores) > 0) {
+					t.Errorf("seed %d, %+v: got %v with %d solutions", seed, o, got, len(st.scores))
+					continue
+				}
+				if len(st.scores) == 0 {
+					continue
+				}
+				if !satisfied(p) {
+					t.Errorf("seed %d, %+v: solution breaks a constraint", seed, o)
+				}
+				if got := o.Value(p.decisions); got != want {
+					t.Errorf("seed %d, %+v: got score %d, want %d", seed, o, got, want)
+				}
+				for j := 1; j < len(improving.scores); j++ {
+					if improving.scores[j] >= improving.scores[j-1] {
+						t.Errorf("seed %d, %+v: scores %v do not improve", seed, o, improving.scores)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSample(t *testing.T) {
+	for _, tt := range []struct {
+		test string
+		n    int
+		k    int
+		want int
+	}{
+		{"enumerated", 4, 5, 5},
+		{"fewer solutions than k", 3, 10, 6},
+		{"restarts", 8, 20, 20},
+	} {
+		p := newPermutations(tt.n)
+		got := p.Sample(tt.k, Settings{Decider: first{}, Seed: 1})
+		if len(got) != tt.want {
+			t.Errorf("test: %s, got %d samples, want %d", tt.test, len(got), tt.want)
+		}
+		seen := map[string]bool{}
+		for _, values := range got {
+			key := fmt.Sprint(values)
+			if seen[key] {
+				t.Errorf("test: %s, sampled %v twice", tt.test, values)
+			}
+			seen[key] = true
+			used := map[int]bool{}
+			for _, v := range values {
+				if v < 0 || used[v] {
+					t.Errorf("test: %s, sampled %v, which is not a permutation", tt.test, values)
+					break
+				}
+				used[v] = true
+			}
+		}
+		if len(p.undecided()) != tt.n {
+			t.Errorf("test: %s, problem was not left in its initial state", tt.test)
+		}
+		again := newPermutations(tt.n).Sample(tt.k, Settings{Decider: first{}, Seed: 1})
+		if fmt.Sprint(again) != fmt.Sprint(got) {
+			t.Errorf("test: %s, the same seed gave %v, then %v", tt.test, got, again)
+		}
+	}
+}
+
+func TestSampleUniform(t *testing.T) {
+	counts := map[string]int{}
+	for seed := int64(0); seed < 600; seed++ {
+		for _, values := range newPermutations(3).Sample(1, Settings{Decider: first{}, Seed: seed}) {
+			counts[fmt.Sprint(values)]++
+		}
+	}
+	if len(counts) != 6 {
+		t.Fatalf("got %d different samples, want 6", len(counts))
+	}
+	for key, count := range counts {
+		if count < 50 || count > 150 {
+			t.Errorf("sampled %s %d times out of 600", key, count)
+		}
+	}
+}
+
+func TestSampleNoSolution(t *testing.T) {
+	p := NewProblem(3, 2)
+	p.AddGroup([]int{0, 1, 2}, distinct{})
+	if got := p.Sample(3, Settings{Decider: first{}}); len(got) != 0 {
+		t.Errorf("got %v from a problem with no solutions", got)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	p := newPermutations(4)
+	outer := p.Checkpoint()
+	p.Set(0, 1)
+	if _, ok := p.Propagate(Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	inner := p.Checkpoint()
+	if err := p.Set(1, 2); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if got := p.Count(Settings{Decider: first{}}); got != 2 {
+		t.Errorf("inside the inner checkpoint, got %d solutions, want 2", got)
+	}
+	if err := p.Rollback(inner); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if got := p.Get(1).Count(); got != 3 {
+		t.Errorf("after the inner rollback, got %d values for decision 1, want 3", got)
+	}
+	if got := p.Count(Settings{Decider: first{}}); got != 6 {
+		t.Errorf("after the inner rollback, got %d solutions, want 6", got)
+	}
+	if err := p.Rollback(outer); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if got := p.Count(Settings{Decider: first{}}); got != 24 {
+		t.Errorf("after the outer rollback, got %d solutions, want 24", got)
+	}
+}
+
+func TestRollbackErrors(t *testing.T) {
+	p := newPermutations(3)
+	outer := p.Checkpoint()
+	inner := p.Checkpoint()
+	p.Set(0, 1)
+	if err := p.Rollback(-1); err == nil {
+		t.Errorf("rolled back to checkpoint -1")
+	}
+	if err := p.Rollback(outer); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	p.Set(0, 2)
+	// The inner checkpoint went with the outer one.
+	if err := p.Rollback(inner); err == nil {
+		t.Errorf("rolled back to a checkpoint already rolled back past")
+	}
+	if got := p.Get(0).Value(); got != 2 {
+		t.Errorf("a failed rollback changed decision 0 to %d", got)
+	}
+}
+
+func TestClone(t *testing.T) {
+	p := newPermutations(4)
+	p.Set(0, 1)
+	q := p.Clone()
+	q.Set(1, 2)
+	if got := p.Count(Settings{Decider: first{}}); got != 6 {
+		t.Errorf("original: got %d solutions, want 6", got)
+	}
+	if got := q.Count(Settings{Decider: first{}}); got != 2 {
+		t.Errorf("clone: got %d solutions, want 2", got)
+	}
+	if !q.Solve(Settings{Decider: first{}}) || p.Get(1).Value() >= 0 {
+		t.Errorf("solving the clone changed the original")
+	}
+}
+
+func (c distinct) MarshalConstraint() (string, interface{}) {
+	return "distinct", nil
+}
+
+func init() {
+	RegisterConstraint("distinct", func(params json.RawMessage) (ConstraintChecker, error) {
+		return distinct{}, nil
+	})
+}
+
+func TestJSON(t *testing.T) {
+	p := newPermutations(5)
+	p.AddNamedGroup("pair", []int{1, 2}, distinct{})
+	p.Set(0, 3)
+	p.Get(1).Restrict(4)
+	if _, ok := p.Propagate(Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	loaded := &Problem{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for i := 0; i < p.Size(); i++ {
+		if got, want := loaded.Get(i).domain.values(nil), p.Get(i).domain.values(nil); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("decision %d: got values %v, want %v", i, got, want)
+		}
+	}
+	if got := loaded.Groups()[1].Name(); got != "pair" {
+		t.Errorf("got group name %q, want %q", got, "pair")
+	}
+	if got, want := loaded.Count(Settings{Decider: first{}}), p.Count(Settings{Decider: first{}}); got != want {
+		t.Errorf("got %d solutions, want %d", got, want)
+	}
+	if again, err := json.Marshal(loaded); err != nil || string(again) != string(data) {
+		t.Errorf("saving again gave %s, %v, want %s", again, err, data)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	p := NewProblem(2, 2)
+	p.AddNamedGroup("forbidden", []int{0, 1}, forbid{0, 1})
+	if _, err := json.Marshal(p); err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Errorf("got error %v, want one naming the group", err)
+	}
+	for _, data := range []string{
+		`{"valueSize":2,"decisions":[[0,1]],"groups":[{"decisions":[0],"constraint":"nonesuch"}]}`,
+		`{"valueSize":2,"decisions":[[0,1]],"groups":[{"decisions":[3],"constraint":"distinct"}]}`,
+		`{"valueSize":-1,"decisions":[[0]],"groups":[]}`,
+		`{"valueSize":0,"decisions":[[0]],"groups":[]}`,
+		`{"valueSize":1000000000,"decisions":[[0]],"groups":[]}`,
+		`{"valueSize":2,"decisions":[[0,2]],"groups":[]}`,
+		`{"valueSize":2,"decisions":[[-1]],"groups":[]}`,
+	} {
+		if err := json.Unmarshal([]byte(data), &Problem{}); err == nil {
+			t.Errorf("loaded %s", data)
+		}
+	}
+}
+
+func TestCheckGroup(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "triple") {
+			t.Errorf("got panic %v, want one naming the group", r)
+		}
+	}()
+	p := NewProblem(3, 2)
+	p.AddNamedGroup("triple", []int{0, 1, 2}, forbid{0, 1})
+}
+
+func TestSetErrors(t *testing.T) {
+	p := newPermutations(3)
+	p.AddNamedGroup("pair", []int{1, 2}, forbid{0, 1})
+	for _, tt := range []struct {
+		test     string
+		i, value int
+		want     string
+	}{
+		{"first", 0, 2, ""},
+		{"no decision", 3, 0, "no decision 3"},
+		{"bad value", 1, 3, "value 3 out of range"},
+		{"repeated", 1, 2, "contradicts group 0"},
+		{"contradicts", 1, 0, "contradicts pair"},
+		{"second", 1, 1, ""},
+		{"ruled out", 1, 0, "decision 1 cannot be 0"},
+	} {
+		err := p.SetChecked(tt.i, tt.value)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("test: %s, got error %v", tt.test, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("test: %s, got error %v, want it to mention %q", tt.test, err, tt.want)
+		}
+	}
+	if _, ok := p.Propagate(Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	if got := p.Get(2).Value(); got != 0 {
+		t.Errorf("got %d for decision 2 after setting the others, want 0", got)
+	}
+}
+
+func TestSetCheckedInConflict(t *testing.T) {
+	p := newPermutations(3)
+	p.Get(2).Restrict(2)
+	err := p.SetChecked(2, 2)
+	if e, ok := err.(*ConflictError); !ok || e.InConflict || !strings.Contains(err.Error(), "already ruled out") {
+		t.Errorf("got error %v for a value ruled out, want one saying so", err)
+	}
+	p.Get(0).RestrictTo(0)
+	p.Get(0).Restrict(0)
+	err = p.SetChecked(1, 1)
+	if e, ok := err.(*ConflictError); !ok || !e.InConflict || !strings.Contains(err.Error(), "already in conflict") {
+		t.Errorf("got error %v in conflict, want one saying so", err)
+	}
+	if _, ok := p.Propagate(Settings{}); ok {
+		t.Errorf("checking a value cleared the conflict")
+	}
+}
+
+func TestSet(t *testing.T) {
+	// Set leaves contradictions for propagation to find.
+	p := newPermutations(3)
+	if err := p.Set(0, 1); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if err := p.Set(1, 1); err != nil {
+		t.Errorf("got error %v for a value not yet ruled out", err)
+	}
+	if _, ok := p.Propagate(Settings{}); ok {
+		t.Errorf("propagation succeeded")
+	}
+	if err := p.Set(2, 3); err == nil {
+		t.Errorf("set a value out of range")
+	}
+}
+
+// backtrackCounter counts decisions and backtracks.
+type backtrackCounter struct {
+	decisions, backtracks int
+}
+
+func (bc *backtrackCounter) CaptureDecision(p *Problem)  { bc.decisions++ }
+func (bc *backtrackCounter) CaptureBacktrack(p *Problem) { bc.backtracks++ }
+
+func TestStats(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		p := NewProblem(6, 6)
+		p.AddGroup([]int{0, 1, 2, 3, 4, 5}, distinct{})
+		p.AddGroup([]int{4, 5}, forbid{0, 1})
+		bc := &backtrackCounter{}
+		var stats Stats
+		if got := p.Count(Settings{Decider: first{}, DecisionTracker: bc, Stats: &stats, Workers: workers}); got == 0 {
+			t.Fatalf("workers %d: no solutions", workers)
+		}
+		if stats.Decisions != bc.decisions || stats.Backtracks != bc.backtracks {
+			t.Errorf("workers %d: got %d decisions and %d backtracks, want %d and %d",
+				workers, stats.Decisions, stats.Backtracks, bc.decisions, bc.backtracks)
+		}
+		if stats.MaxDepth != 5 {
+			t.Errorf("workers %d: got max depth %d, want 5", workers, stats.MaxDepth)
+		}
+		if stats.Applies["distinct"] == 0 || stats.Applies["forbid"] == 0 || len(stats.Applies) != 2 {
+			t.Errorf("workers %d: got applies %v", workers, stats.Applies)
+		}
+		if stats.Wall <= 0 || stats.Propagation <= 0 {
+			t.Errorf("workers %d: got wall time %v and propagation time %v", workers, stats.Wall, stats.Propagation)
+		}
+	}
+}
+
+func TestSampleStats(t *testing.T) {
+	// Too many solutions to enumerate, so Sample also solves once
+	// per sample.
+	p := newPermutations(7)
+	bc := &backtrackCounter{}
+	var stats Stats
+	if got := p.Sample(3, Settings{Decider: first{}, DecisionTracker: bc, Stats: &stats}); len(got) != 3 {
+		t.Fatalf("got %d samples, want 3", len(got))
+	}
+	if stats.Decisions != bc.decisions || stats.Backtracks != bc.backtracks {
+		t.Errorf("got %d decisions and %d backtracks, want %d and %d",
+			stats.Decisions, stats.Backtracks, bc.decisions, bc.backtracks)
+	}
+	if stats.Applies["distinct"] == 0 {
+		t.Errorf("got applies %v", stats.Applies)
+	}
+}
+
+type never struct{}
+
+func (c never) Init(all []*Decision, size int) {}
+func (c never) Apply(all, dirty []*Decision) bool {
+	return false
+}
+
+func TestStatsAfterConflict(t *testing.T) {
+	// The second group is never applied, since the first fails.
+	p := NewProblem(1, 2)
+	p.AddGroup([]int{0}, never{})
+	p.AddGroup([]int{0}, distinct{})
+	var stats Stats
+	if _, ok := p.Propagate(Settings{Stats: &stats}); ok {
+		t.Fatalf("propagation succeeded")
+	}
+	if stats.Applies["never"] != 1 || len(stats.Applies) != 1 {
+		t.Errorf("got applies %v, want only never once", stats.Applies)
+	}
+}
+
+// recorder logs each time it is applied.
+type recorder struct {
+	name string
+	cost int
+	log  *[]string
+}
+
+func (c recorder) Init(all []*Decision, size int) {}
+func (c recorder) Apply(all, dirty []*Decision) bool {
+	*c.log = append(*c.log, c.name)
+	return true
+}
+func (c recorder) Cost() int { return c.cost }
+
+func TestPropagationOrder(t *testing.T) {
+	var log []string
+	p := NewProblem(4, 4)
+	p.AddGroup([]int{0, 1, 2, 3}, recorder{"loop", CostExpensive, &log})
+	p.AddGroup([]int{0, 1}, recorder{"count", CostLinear, &log})
+	p.AddGroup([]int{0, 1}, distinct{})
+	p.AddGroup([]int{1, 2}, recorder{"pair", CostCheap, &log})
+	p.AddGroup([]int{2, 3}, distinct{})
+	p.Set(0, 0)
+	log = nil
+	if _, ok := p.Propagate(Settings{}); !ok {
+		t.Fatalf("propagation failed")
+	}
+	// Setting decision 0 makes distinct restrict decision 1, so the
+	// pair is applied again before the dearer constraints.
+	want := "[pair pair count loop]"
+	if got := fmt.Sprint(log); got != want {
+		t.Errorf("got applies %s, want %s", got, want)
+	}
 }
@@ -0,0 +1,106 @@
+package csp
+
+import (
+	"math/bits"
+)
+
+// A domain is the set of values still possible for a Decision, kept
+// as a bitset. Values below 64 live in a single word, which covers
+// nearly every puzzle; larger value sets spill over into extra words.
+type domain struct {
+	lo    uint64
+	hi    []uint64
+	count int
+}
+
+func newDomain(size int) domain {
+	result := domain{count: size}
+	if size >= 64 {
+		result.lo = ^uint64(0)
+		result.hi = make([]uint64, (size-1)/64)
+		for i := 64; i < size; i++ {
+			result.hi[i/64-1] |= 1 << (i % 64)
+		}
+	} else {
+		result.lo = 1<<size - 1
+	}
+	return result
+}
+
+func (dom *domain) word(i int) *uint64 {
+	if i < 64 {
+		return &dom.lo
+	}
+	if j := i/64 - 1; j < len(dom.hi) {
+		return &dom.hi[j]
+	}
+	return nil
+}
+
+func (dom *domain) has(i int) bool {
+	if i < 0 {
+		return false
+	}
+	w := dom.word(i)
+	return w != nil && *w&(1<<(i%64)) != 0
+}
+
+// remove clears i and reports whether it was present.
+func (dom *domain) remove(i int) bool {
+	if !dom.has(i) {
+		return false
+	}
+	*dom.word(i) &^= 1 << (i % 64)
+	dom.count--
+	return true
+}
+
+func (dom *domain) add(i int) {
+	*dom.word(i) |= 1 << (i % 64)
+	dom.count++
+}
+
+// first returns the lowest value in the domain, or -1 if it is empty.
+func (dom *domain) first() int {
+	if dom.lo != 0 {
+		return bits.TrailingZeros64(dom.lo)
+	}
+	for j, w := range dom.hi {
+		if w != 0 {
+			return (j+1)*64 + bits.TrailingZeros64(w)
+		}
+	}
+	return -1
+}
+
+// last returns the highest value in the domain, or -1 if it is empty.
+func (dom *domain) last() int {
+	for j := len(dom.hi) - 1; j >= 0; j-- {
+		if w := dom.hi[j]; w != 0 {
+			return (j+1)*64 + bits.Len64(w) - 1
+		}
+	}
+	return bits.Len64(dom.lo) - 1
+}
+
+// values appends the values in the domain to result in ascending
+// order.
+func (dom *domain) values(result []int) []int {
+	for w := dom.lo; w != 0; w &= w - 1 {
+		result = append(result, bits.TrailingZeros64(w))
+	}
+	for j, w := range dom.hi {
+		for ; w != 0; w &= w - 1 {
+			result = append(result, (j+1)*64+bits.TrailingZeros64(w))
+		}
+	}
+	return result
+}
+
+func (dom *domain) clone() domain {
+	result := *dom
+	if dom.hi != nil {
+		result.hi = append([]uint64(nil), dom.hi...)
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package csp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// A ConstraintMarshaler is a ConstraintChecker that can be saved
+// with its Problem. The name must be registered with
+// RegisterConstraint for the Problem to be loaded again, and params
+// must marshal to JSON.
+type ConstraintMarshaler interface {
+	MarshalConstraint() (name string, params interface{})
+}
+
+// A ConstraintUnmarshaler rebuilds a constraint from the params saved
+// by its ConstraintMarshaler.
+type ConstraintUnmarshaler func(params json.RawMessage) (ConstraintChecker, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]ConstraintUnmarshaler{}
+)
+
+// RegisterConstraint makes constraints saved under name loadable. It
+// panics if name is already registered.
+func RegisterConstraint(name string, unmarshal ConstraintUnmarshaler) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("csp: constraint " + name + " registered twice")
+	}
+	registry[name] = unmarshal
+}
+
+// maxValueSize bounds the value size of a loaded Problem, since each
+// decision's domain takes space in proportion to it.
+const maxValueSize = 1 << 16
+
+type problemJSON struct {
+	ValueSize int `json:"valueSize"`
+	// Decisions holds the values still possible for each decision.
+	Decisions [][]int     `json:"decisions"`
+	Groups    []groupJSON `json:"groups"`
+}
+
+type groupJSON struct {
+	Name       string          `json:"name,omitempty"`
+	Decisions  []int           `json:"decisions"`
+	Constraint string          `json:"constraint"`
+	Params     json.RawMessage `json:"params,omitempty"`
+}
+
+// MarshalJSON saves the Problem's current domains and its groups. It
+// fails if any group's constraint is not a ConstraintMarshaler, such
+// as one that depends on data outside the Problem like a word list.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	pj := problemJSON{ValueSize: p.valueSize, Decisions: [][]int{}, Groups: []groupJSON{}}
+	for _, d := range p.decisions {
+		pj.Decisions = append(pj.Decisions, d.domain.values([]int{}))
+	}
+	for _, g := range p.groups {
+		c, ok := g.constraint.(ConstraintMarshaler)
+		if !ok {
+			return nil, fmt.Errorf("csp: %s: %T cannot be saved, as it is not a ConstraintMarshaler", g.describe(), g.constraint)
+		}
+		gj := groupJSON{Name: g.name}
+		var params interface{}
+		gj.Constraint, params = c.MarshalConstraint()
+		if params != nil {
+			var err error
+			if gj.Params, err = json.Marshal(params); err != nil {
+				return nil, fmt.Errorf("csp: %s: %v", g.describe(), err)
+			}
+		}
+		for _, d := range g.decisions {
+			gj.Decisions = append(gj.Decisions, d.index)
+		}
+		pj.Groups = append(pj.Groups, gj)
+	}
+	return json.Marshal(pj)
+}
+
+// UnmarshalJSON replaces the Problem with one loaded from data saved
+// by MarshalJSON. Restrictions that had not been propagated when the
+// Problem was saved are propagated by the next solve.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var pj problemJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	if pj.ValueSize < 1 || pj.ValueSize > maxValueSize {
+		return fmt.Errorf("csp: value size %d is not between 1 and %d", pj.ValueSize, maxValueSize)
+	}
+	q := NewProblem(len(pj.Decisions), pj.ValueSize)
+	for i, gj := range pj.Groups {
+		registryMu.RLock()
+		unmarshal, ok := registry[gj.Constraint]
+		registryMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("csp: group %d: unknown constraint %q", i, gj.Constraint)
+		}
+		c, err := unmarshal(gj.Params)
+		if err != nil {
+			return fmt.Errorf("csp: group %d: %v", i, err)
+		}
+		for _, d := range gj.Decisions {
+			if d < 0 || d >= q.Size() {
+				return fmt.Errorf("csp: group %d: no decision %d", i, d)
+			}
+		}
+		if err := checkGroup(c, len(gj.Decisions), pj.ValueSize); err != nil {
+			return fmt.Errorf("csp: group %d: %v", i, err)
+		}
+		q.AddNamedGroup(gj.Name, gj.Decisions, c)
+	}
+	for i, values := range pj.Decisions {
+		possible := map[int]bool{}
+		for _, v := range values {
+			if v < 0 || v >= pj.ValueSize {
+				return fmt.Errorf("csp: decision %d: value %d out of range", i, v)
+			}
+			possible[v] = true
+		}
+		q.decisions[i].RestrictToSet(possible)
+	}
+	// The decisions point back at their Problem.
+	*p = *q
+	for _, d := range p.decisions {
+		d.p = p
+	}
+	return nil
+}
+
+// describe names the group for error messages.
+func (g *Group) describe() string {
+	if g.name != "" {
+		return g.name
+	}
+	return fmt.Sprintf("group %d", g.index)
+}
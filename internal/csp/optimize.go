@@ -0,0 +1,111 @@
+package csp
+
+import (
+	"context"
+)
+
+// An Objective scores solutions for Optimize, which looks for the
+// solution with the lowest score. To maximize, negate the score.
+type Objective interface {
+	// Value returns the score of a solution, in which every
+	// decision is decided.
+	Value(all []*Decision) int
+	// Bound returns a lower bound on the score of any solution
+	// reachable from the current domains. The tighter the bound,
+	// the more of the search can be skipped.
+	Bound(all []*Decision) int
+}
+
+// Optimize searches for the solution with the lowest score under o,
+// and leaves the Problem holding it. Each better solution is passed
+// to the SolutionTracker as it is found; the last one passed is
+// optimal. It returns false if the Problem has no solution.
+func (p *Problem) Optimize(o Objective, s Settings) bool {
+	ok, _ := p.OptimizeContext(context.Background(), o, s)
+	return ok
+}
+
+// OptimizeContext is like Optimize, but gives up as described in
+// SolveContext. If it gives up after finding a solution, the Problem
+// holds the best one found, which is not known to be optimal.
+func (p *Problem) OptimizeContext(ctx context.Context, o Objective, s Settings) (bool, error) {
+	s.FindAll = false
+	n, err := p.solve(ctx, s, o)
+	return n > 0, err
+}
+
+// improve records the solution held by p if it beats the best so
+// far. It must be called with sr.mu held.
+func (sr *search) improve(p *Problem, score int) bool {
+	if sr.solutions > 0 && score >= sr.best {
+		return false
+	}
+	sr.best = score
+	sr.bestValues = sr.bestValues[:0]
+	for _, d := range p.decisions {
+		sr.bestValues = append(sr.bestValues, d.Value())
+	}
+	return true
+}
+
+// restoreBest leaves the search's Problem holding the best solution
+// found.
+func (sr *search) restoreBest() {
+	p := sr.p
+	p.undoTo(sr.base)
+	p.snapshot()
+	for i, v := range sr.bestValues {
+		p.decisions[i].RestrictTo(v)
+	}
+	p.clearDirty()
+}
+
+// withinBound reports whether the solver's Problem could still lead
+// to a solution better than the best so far.
+func (sv *solver) withinBound() bool {
+	sr := sv.search
+	if sr.objective == nil {
+		return true
+	}
+	sr.mu.Lock()
+	found, best := sr.solutions > 0, sr.best
+	sr.mu.Unlock()
+	return !found || sr.objective.Bound(sv.p.decisions) < best
+}
+
+// MinimizeSum returns an Objective scoring solutions by the sum of
+// the values of the given decisions.
+func MinimizeSum(decisions []int) Objective {
+	return sumObjective{decisions, 1}
+}
+
+// MaximizeSum is like MinimizeSum, but prefers larger sums. Scores
+// are the negated sum.
+func MaximizeSum(decisions []int) Objective {
+	return sumObjective{decisions, -1}
+}
+
+type sumObjective struct {
+	decisions []int
+	sign      int
+}
+
+func (o sumObjective) Value(all []*Decision) int {
+	result := 0
+	for _, i := range o.decisions {
+		result += o.sign * all[i].Value()
+	}
+	return result
+}
+
+func (o sumObjective) Bound(all []*Decision) int {
+	result := 0
+	for _, i := range o.decisions {
+		if o.sign > 0 {
+			result += all[i].domain.first()
+		} else {
+			result -= all[i].domain.last()
+		}
+	}
+	return result
+}
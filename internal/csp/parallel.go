@@ -0,0 +1,119 @@
+package csp
+
+import (
+	"sync"
+)
+
+// Splitting stops once there are this many subtrees per worker, or
+// once the tree has been split this many levels deep.
+const (
+	splitsPerWorker = 8
+	maxSplitDepth   = 6
+)
+
+// An assignment sets a decision, identified by its index, to a value.
+type assignment struct {
+	d, value int
+}
+
+// parallelSolve splits the search tree into subtrees by making the
+// first few decisions up front, then hands the subtrees out to
+// Workers goroutines that each search their own clone of the Problem.
+func (sr *search) parallelSolve() {
+	jobs := sr.split()
+	if sr.err != nil {
+		return
+	}
+	ch := make(chan []assignment)
+	var wg sync.WaitGroup
+	var workers []*Stats
+	for i := 0; i < sr.Workers; i++ {
+		sv := &solver{search: sr, p: sr.p.Clone(), rng: sr.newRand(i + 1)}
+		sv.p.reasons = sr.Backjump
+		if sr.Stats != nil {
+			sv.p.stats = newStats()
+			workers = append(workers, sv.p.stats)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				// The whole job is made at the first level.
+				sv.p.snapshot()
+				sv.guesses = []guess{{1, job}}
+				// Each assignment in the job counts towards the
+				// depth.
+				sv.p.base = 1 - len(job)
+				if sv.p.assign(job) && (!sr.Probe || sv.p.probe()) {
+					sv.run()
+				}
+				sv.p.undoTo(0)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		if sr.stopped() {
+			break
+		}
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+	for _, st := range workers {
+		sr.p.stats.add(st)
+	}
+}
+
+// split returns the consistent partial assignments found by
+// expanding the search tree breadth first until there is enough work
+// to go around. Each value it tries counts as a decision, so it
+// returns nil if the search gives up while splitting.
+func (sr *search) split() [][]assignment {
+	p := sr.p
+	base := len(p.marks)
+	jobs := [][]assignment{nil}
+	for depth := 0; depth < maxSplitDepth && len(jobs) < splitsPerWorker*sr.Workers; depth++ {
+		var next [][]assignment
+		grew := false
+		for _, job := range jobs {
+			p.snapshot()
+			p.assign(job)
+			if ds := p.undecided(); len(ds) == 0 {
+				next = append(next, job)
+			} else {
+				grew = true
+				d := sr.Decide(ds, p.groups)
+				for _, v := range sr.values(d, nil) {
+					if !sr.decide(p) {
+						p.undoTo(base)
+						return nil
+					}
+					p.snapshot()
+					d.RestrictTo(v)
+					if p.check() {
+						next = append(next, append(job[:len(job):len(job)], assignment{d.index, v}))
+					}
+					p.undo()
+				}
+			}
+			p.undo()
+		}
+		jobs = next
+		if !grew {
+			break
+		}
+	}
+	return jobs
+}
+
+// assign applies each assignment in turn, propagating as it goes, and
+// returns false on a conflict.
+func (p *Problem) assign(as []assignment) bool {
+	for _, a := range as {
+		p.decisions[a.d].RestrictTo(a.value)
+		if !p.check() {
+			return false
+		}
+	}
+	return true
+}
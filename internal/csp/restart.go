@@ -0,0 +1,63 @@
+package csp
+
+import (
+	"math"
+)
+
+// A RestartStrategy gives the number of backtracks allowed in each
+// run of a restarting search. A limit of zero or less means no limit:
+// the run goes on until the search finishes, and is never restarted.
+type RestartStrategy interface {
+	Limit(run int) int
+}
+
+// LubyRestarts returns the Luby sequence 1, 1, 2, 1, 1, 2, 4, 1, ...
+// scaled by unit. It is within a constant factor of the best possible
+// strategy when nothing is known about the runtime distribution.
+func LubyRestarts(unit int) RestartStrategy {
+	return lubyRestarts{unit}
+}
+
+type lubyRestarts struct {
+	unit int
+}
+
+func (r lubyRestarts) Limit(run int) int {
+	return r.unit * luby(run+1)
+}
+
+// luby returns the i-th element of the Luby sequence, counting from 1.
+func luby(i int) int {
+	for k := 1; ; k++ {
+		if i == 1<<k-1 {
+			return 1 << (k - 1)
+		}
+		if i < 1<<k-1 {
+			return luby(i - 1<<(k-1) + 1)
+		}
+	}
+}
+
+// GeometricRestarts returns limits starting at base and growing by
+// factor each run. Once a limit would overflow, or if base is less
+// than 1, it is zero, meaning no limit. It panics unless factor is
+// greater than 1, since the search could otherwise restart forever.
+func GeometricRestarts(base int, factor float64) RestartStrategy {
+	if !(factor > 1) {
+		panic("csp: geometric restart factor must be greater than 1")
+	}
+	return geometricRestarts{base, factor}
+}
+
+type geometricRestarts struct {
+	base   int
+	factor float64
+}
+
+func (r geometricRestarts) Limit(run int) int {
+	limit := float64(r.base) * math.Pow(r.factor, float64(run))
+	if limit >= math.MaxInt32 {
+		return 0
+	}
+	return int(limit)
+}
@@ -0,0 +1,113 @@
+package csp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+)
+
+// Sample enumerates up to this many solutions to sample from before
+// falling back to randomized search.
+const sampleEnumerate = 1000
+
+// Sample returns up to k distinct solutions to the Problem chosen at
+// random, each as the values of its decisions, and leaves the Problem
+// in its initial state. Problems with few enough solutions are
+// sampled uniformly from the full list; otherwise each sample is the
+// first solution found by a restarting search with its own seed,
+// derived from s.Seed, which is close to uniform but not exactly so.
+// Fewer than k solutions are returned if no more can be found. The
+// SolutionTracker and FindAll settings are ignored, and Stats, if set,
+// sums up all the solves made to take the samples.
+func (p *Problem) Sample(k int, s Settings) [][]int {
+	result, _ := p.SampleContext(context.Background(), k, s)
+	return result
+}
+
+// SampleContext is like Sample, but gives up as described in
+// SolveContext, returning the solutions sampled so far.
+func (p *Problem) SampleContext(ctx context.Context, k int, s Settings) ([][]int, error) {
+	base := len(p.marks)
+	defer p.undoTo(base)
+	// Each solve fills in s.Stats afresh, so its stats are added up
+	// in total.
+	total := s.Stats
+	if total != nil {
+		*total = *newStats()
+		s.Stats = newStats()
+		defer total.finish()
+	}
+	tally := func() {
+		if total != nil {
+			total.addSolve(s.Stats)
+		}
+	}
+	rng := rand.New(rand.NewSource(s.Seed))
+	r := &reservoir{k: k, rng: rng}
+	s.SolutionTracker, s.FindAll = r, true
+	s.MaxSolutions = sampleEnumerate
+	n, err := p.CountContext(ctx, s)
+	tally()
+	p.undoTo(base)
+	if err != nil || n < sampleEnumerate || k <= 0 {
+		rng.Shuffle(len(r.samples), func(i, j int) {
+			r.samples[i], r.samples[j] = r.samples[j], r.samples[i]
+		})
+		return r.samples, err
+	}
+	var result [][]int
+	seen := map[string]bool{}
+	if s.Restarts == nil {
+		s.Restarts = LubyRestarts(16)
+	}
+	s.SolutionTracker, s.FindAll, s.MaxSolutions = nil, false, 0
+	// Give up once a run of attempts turns up nothing new.
+	for misses := 0; len(result) < k && misses < 10*k; {
+		s.Seed = rng.Int63()
+		ok, err := p.SolveContext(ctx, s)
+		tally()
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			break
+		}
+		values := p.values()
+		p.undoTo(base)
+		if key := fmt.Sprint(values); !seen[key] {
+			seen[key] = true
+			result = append(result, values)
+			misses = 0
+		} else {
+			misses++
+		}
+	}
+	return result, nil
+}
+
+// values returns the value of each decision.
+func (p *Problem) values() []int {
+	var result []int
+	for _, d := range p.decisions {
+		result = append(result, d.Value())
+	}
+	return result
+}
+
+// A reservoir keeps a uniform random sample of k of the solutions it
+// is passed.
+type reservoir struct {
+	k       int
+	rng     *rand.Rand
+	seen    int
+	samples [][]int
+}
+
+func (r *reservoir) CaptureSolution(p *Problem) {
+	r.seen++
+	if len(r.samples) < r.k {
+		r.samples = append(r.samples, p.values())
+	} else if i := r.rng.Intn(r.seen); i < r.k {
+		r.samples[i] = p.values()
+	}
+}
@@ -0,0 +1,58 @@
+package csp
+
+import (
+	"reflect"
+	"time"
+)
+
+// Stats summarizes the work done by a single solve, for comparing
+// Deciders and other settings.
+type Stats struct {
+	Decisions  int
+	Backtracks int
+	// MaxDepth is the greatest number of guesses in effect at once.
+	MaxDepth int
+	// Applies counts the calls to each type of constraint's Apply,
+	// keyed by type name.
+	Applies map[string]int
+	// Propagation is the time spent propagating. In a parallel
+	// search it is summed over the workers.
+	Propagation time.Duration
+	Wall        time.Duration
+
+	// applies is keyed by type, which is cheaper than naming every
+	// constraint as it is applied.
+	applies map[reflect.Type]int
+}
+
+func newStats() *Stats {
+	return &Stats{applies: map[reflect.Type]int{}}
+}
+
+// add merges in the stats of a parallel worker.
+func (st *Stats) add(o *Stats) {
+	st.Backtracks += o.Backtracks
+	if o.MaxDepth > st.MaxDepth {
+		st.MaxDepth = o.MaxDepth
+	}
+	st.Propagation += o.Propagation
+	for t, n := range o.applies {
+		st.applies[t] += n
+	}
+}
+
+// addSolve merges in the stats of a whole solve, for calls that make
+// several.
+func (st *Stats) addSolve(o *Stats) {
+	st.Decisions += o.Decisions
+	st.Wall += o.Wall
+	st.add(o)
+}
+
+// finish fills in Applies.
+func (st *Stats) finish() {
+	st.Applies = map[string]int{}
+	for t, n := range st.applies {
+		st.Applies[constraintName(t)] += n
+	}
+}
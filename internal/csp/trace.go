@@ -0,0 +1,119 @@
+package csp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A Deduction records a single step taken while solving: either a
+// constraint or probing removing a possibility from a decision, or
+// the search guessing a value for a decision.
+type Deduction struct {
+	// Decision is the index of the decision, and Value the value
+	// removed from it or guessed for it.
+	Decision int `json:"decision"`
+	Value    int `json:"value"`
+	// Guess is set for search guesses and Probe for values removed
+	// by probing, neither of which have a group.
+	Guess bool `json:"guess,omitempty"`
+	Probe bool `json:"probe,omitempty"`
+	// Group is the index of the group whose constraint made the
+	// deduction, in the order the groups were added, and Name and
+	// Constraint identify the group and its constraint's type.
+	Group      int    `json:"group"`
+	Name       string `json:"name,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+	// Depth is the number of guesses in effect when the deduction
+	// was made. Deductions at depth 0 follow from the givens alone.
+	Depth int `json:"depth"`
+}
+
+// A Trace records the deductions made while solving a Problem,
+// including those later undone by backtracking. The names, if set,
+// are used in place of indexes by String.
+type Trace struct {
+	Deductions    []Deduction `json:"deductions"`
+	DecisionNames []string    `json:"-"`
+	ValueNames    []string    `json:"-"`
+}
+
+func (t *Trace) record(p *Problem, d *Decision, value int, g *Group) {
+	t.Deductions = append(t.Deductions, Deduction{
+		Decision:   d.index,
+		Value:      value,
+		Group:      g.index,
+		Name:       g.name,
+		Constraint: constraintName(reflect.TypeOf(g.constraint)),
+		Depth:      p.Depth(),
+	})
+}
+
+func (t *Trace) recordGuess(p *Problem, d *Decision, value int) {
+	t.Deductions = append(t.Deductions, Deduction{
+		Decision: d.index,
+		Value:    value,
+		Guess:    true,
+		Group:    -1,
+		Depth:    p.Depth(),
+	})
+}
+
+func (t *Trace) recordProbe(p *Problem, d *Decision, value int) {
+	t.Deductions = append(t.Deductions, Deduction{
+		Decision: d.index,
+		Value:    value,
+		Probe:    true,
+		Group:    -1,
+		Depth:    p.Depth(),
+	})
+}
+
+// constraintName returns the unqualified name of a constraint's
+// type, for example "unique" for the checker returned by
+// constraints.Unique.
+func constraintName(t reflect.Type) string {
+	name := t.String()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func (t *Trace) decisionName(i int) string {
+	if i < len(t.DecisionNames) {
+		return t.DecisionNames[i]
+	}
+	return fmt.Sprintf("decision %d", i)
+}
+
+func (t *Trace) valueName(v int) string {
+	if v < len(t.ValueNames) {
+		return t.ValueNames[v]
+	}
+	return fmt.Sprint(v)
+}
+
+// Describe returns a human-readable description of a deduction, for
+// example "row 3 unique removed 7 from r3c5".
+func (t *Trace) Describe(ded Deduction) string {
+	if ded.Guess {
+		return fmt.Sprintf("guessed %s for %s", t.valueName(ded.Value), t.decisionName(ded.Decision))
+	}
+	if ded.Probe {
+		return fmt.Sprintf("probing removed %s from %s", t.valueName(ded.Value), t.decisionName(ded.Decision))
+	}
+	group := ded.Name
+	if group == "" {
+		group = fmt.Sprintf("group %d", ded.Group)
+	}
+	return fmt.Sprintf("%s %s removed %s from %s", group, ded.Constraint, t.valueName(ded.Value), t.decisionName(ded.Decision))
+}
+
+// String describes every deduction, one per line, indented by depth.
+func (t *Trace) String() string {
+	var b strings.Builder
+	for _, ded := range t.Deductions {
+		b.WriteString(strings.Repeat("  ", ded.Depth))
+		b.WriteString(t.Describe(ded))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+// Package grade estimates how difficult a puzzle is by watching a
+// csp solver work through it.
+package grade
+
+import (
+	"fmt"
+
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+type Category int
+
+const (
+	// Easy puzzles are solved by propagation alone.
+	Easy Category = iota
+	// Medium puzzles need guessing, but little backtracking.
+	Medium
+	// Hard puzzles need a fair amount of backtracking.
+	Hard
+	// Brutal puzzles need a lot of backtracking.
+	Brutal
+)
+
+func (c Category) String() string {
+	switch c {
+	case Easy:
+		return "easy"
+	case Medium:
+		return "medium"
+	case Hard:
+		return "hard"
+	case Brutal:
+		return "brutal"
+	}
+	return fmt.Sprintf("Category(%d)", int(c))
+}
+
+// Backtrack counts at or below which a puzzle that needs guessing is
+// graded Medium or Hard.
+const (
+	mediumBacktracks = 5
+	hardBacktracks   = 100
+)
+
+// A Result measures the work needed to solve a puzzle.
+type Result struct {
+	// Guessing is set if propagation alone does not solve the
+	// puzzle. Undecided is the number of decisions left at that
+	// point.
+	Guessing  bool
+	Undecided int
+	// Rounds counts rounds of propagation, Decisions and Backtracks
+	// count guesses made and undone, and MaxDepth is the most
+	// guesses in effect at once.
+	Rounds     int
+	Decisions  int
+	Backtracks int
+	MaxDepth   int
+	// Score combines the above into a single number, higher being
+	// harder, and Category summarizes it.
+	Score    int
+	Category Category
+}
+
+// A tracker gathers a Result through the csp tracker hooks.
+type tracker struct {
+	r *Result
+}
+
+func (t tracker) CaptureDecision(p *csp.Problem) {
+	t.r.Decisions++
+	if d := p.Depth() + 1; d > t.r.MaxDepth {
+		t.r.MaxDepth = d
+	}
+}
+
+func (t tracker) CaptureBacktrack(p *csp.Problem) {
+	t.r.Backtracks++
+}
+
+func (t tracker) CapturePropagation(p *csp.Problem) {
+	t.r.Rounds++
+}
+
+// Grade solves p, first by propagation alone and then by searching
+// with the given decider, and returns the work that took. It returns
+// false if p has no solution. The result depends on the decider, so
+// grades are only comparable when made with the same one; decide.Min
+// is a good default. Grade leaves p solved.
+func Grade(p *csp.Problem, d csp.Decider) (Result, bool) {
+	var r Result
+	s := csp.Settings{DecisionTracker: tracker{&r}, Decider: d}
+	undecided, ok := p.Propagate(s)
+	if !ok {
+		return r, false
+	}
+	r.Undecided = len(undecided)
+	r.Guessing = len(undecided) > 0
+	if r.Guessing && !p.Solve(s) {
+		return r, false
+	}
+	r.Score = r.Rounds + 10*r.Decisions + 20*r.Backtracks + 10*r.MaxDepth
+	switch {
+	case !r.Guessing:
+		r.Category = Easy
+	case r.Backtracks <= mediumBacktracks:
+		r.Category = Medium
+	case r.Backtracks <= hardBacktracks:
+		r.Category = Hard
+	default:
+		r.Category = Brutal
+	}
+	return r, true
+}
@@ -0,0 +1,112 @@
+package grade
+
+import (
+	"testing"
+
+	"github.com/offpath/puzzleutils/internal/decide"
+	"github.com/offpath/puzzleutils/internal/puzzle"
+)
+
+func sudoku(givens string) func() *puzzle.Puzzle {
+	return func() *puzzle.Puzzle {
+		p := puzzle.NewSudokuPuzzle()
+		p.Init(givens)
+		return p.Puzzle
+	}
+}
+
+var gradeTests = []struct {
+	name   string
+	puzzle func() *puzzle.Puzzle
+	want   Category
+}{
+	{
+		name: "easy sudoku",
+		puzzle: sudoku("53..7...." +
+			"6..195..." +
+			".98....6." +
+			"8...6...3" +
+			"4..8.3..1" +
+			"7...2...6" +
+			".6....28." +
+			"...419..5" +
+			"....8..79"),
+		want: Easy,
+	},
+	{
+		name: "medium sudoku",
+		puzzle: sudoku("..9748..." +
+			"7........" +
+			".2.1.9..." +
+			"..7...24." +
+			".64.1.59." +
+			".98...3.." +
+			"...8.3.2." +
+			"........6" +
+			"...2759.."),
+		want: Medium,
+	},
+	{
+		name: "hard sudoku",
+		puzzle: sudoku("1....7.9." +
+			".3..2...8" +
+			"..96..5.." +
+			"..53..9.." +
+			".1..8...2" +
+			"6....4..." +
+			"3......1." +
+			".4......7" +
+			"..7...3.."),
+		want: Hard,
+	},
+	{
+		name: "brutal sudoku",
+		puzzle: sudoku("........." +
+			".....3.85" +
+			"..1.2...." +
+			"...5.7..." +
+			"..4...1.." +
+			".9......." +
+			"5......73" +
+			"..2.1...." +
+			"....4...9"),
+		want: Brutal,
+	},
+	{
+		name: "slitherlink",
+		puzzle: func() *puzzle.Puzzle {
+			return puzzle.NewSlitherlinkPuzzle(`...1.
+32.2.
+.22..
+.223.
+.22.3`).Puzzle
+		},
+		want: Brutal,
+	},
+}
+
+func TestGrade(t *testing.T) {
+	for _, tt := range gradeTests {
+		r, ok := Grade(tt.puzzle().Problem(), &decide.Min{})
+		if !ok {
+			t.Errorf("test: %s, failed to solve", tt.name)
+			continue
+		}
+		if r.Category != tt.want {
+			t.Errorf("test: %s, got %v (%+v), want %v", tt.name, r.Category, r, tt.want)
+		}
+		if r.Guessing != (r.Decisions > 0) {
+			t.Errorf("test: %s, guessing is %v but made %d decisions", tt.name, r.Guessing, r.Decisions)
+		}
+		// Grades are stable.
+		if r2, _ := Grade(tt.puzzle().Problem(), &decide.Min{}); r2 != r {
+			t.Errorf("test: %s, regrading gave %+v, want %+v", tt.name, r2, r)
+		}
+		// Checkpoints taken before grading are not guesses.
+		p := tt.puzzle().Problem()
+		p.Checkpoint()
+		if r2, _ := Grade(p, &decide.Min{}); r2 != r {
+			t.Errorf("test: %s, grading after a checkpoint gave %+v, want %+v", tt.name, r2, r)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Package order provides csp.ValueOrderers, which choose the order
+// in which the solver tries the values of a decision.
+package order
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/offpath/puzzleutils/internal/csp"
+)
+
+// Ascending tries values in numeric order, which is also what the
+// solver does without a ValueOrderer.
+type Ascending struct{}
+
+func (o *Ascending) Order(d *csp.Decision, values []int) []int {
+	return values
+}
+
+// Random tries values in a random order, reproducible from its seed.
+type Random struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func NewRandom(seed int64) *Random {
+	return &Random{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (o *Random) Order(d *csp.Decision, values []int) []int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rng.Shuffle(len(values), func(i, j int) {
+		values[i], values[j] = values[j], values[i]
+	})
+	return values
+}
+
+// LeastConstraining tries first the values that are still possible
+// for the fewest other decisions sharing a group with the decision,
+// since those rule out the least elsewhere.
+type LeastConstraining struct{}
+
+func (o *LeastConstraining) Order(d *csp.Decision, values []int) []int {
+	peers := map[*csp.Decision]bool{}
+	for _, g := range d.Groups() {
+		for _, d2 := range g.Decisions() {
+			if d2 != d {
+				peers[d2] = true
+			}
+		}
+	}
+	cost := map[int]int{}
+	for _, v := range values {
+		for d2 := range peers {
+			if d2.Possible(v) {
+				cost[v]++
+			}
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		return cost[values[i]] < cost[values[j]]
+	})
+	return values
+}
+
+// Weighted tries values with higher weights first. Values without a
+// weight count as zero.
+type Weighted struct {
+	Weights []float64
+}
+
+func (o *Weighted) weight(v int) float64 {
+	if v < len(o.Weights) {
+		return o.Weights[v]
+	}
+	return 0
+}
+
+func (o *Weighted) Order(d *csp.Decision, values []int) []int {
+	sort.SliceStable(values, func(i, j int) bool {
+		return o.weight(values[i]) > o.weight(values[j])
+	})
+	return values
+}
+
+// englishFrequencies holds how often each letter A through Z appears
+// in English text, in percent.
+var englishFrequencies = []float64{
+	8.17, 1.29, 2.78, 4.25, 12.70, 2.23, 2.02, 6.09, 6.97, 0.15, 0.77, 4.03, 2.41,
+	6.75, 7.51, 1.93, 0.10, 5.99, 6.33, 9.06, 2.76, 0.98, 2.36, 0.15, 1.97, 0.07,
+}
+
+// NewEnglishLetters returns a Weighted that weights the values A
+// through Z by how often each letter appears in English text, for
+// puzzles whose value set is the alphabet such as dropquotes.
+func NewEnglishLetters() *Weighted {
+	return &Weighted{append([]float64(nil), englishFrequencies...)}
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/offpath/puzzleutils/internal/csp"
 	"github.com/offpath/puzzleutils/internal/decide"
+	"github.com/offpath/puzzleutils/internal/order"
 	"github.com/offpath/puzzleutils/internal/tracker"
 	"github.com/offpath/puzzleutils/internal/trie"
 )
@@ -130,3 +131,33 @@ func TestDropquote(t *testing.T) {
 		}
 	}
 }
+
+func TestDropquoteValueOrder(t *testing.T) {
+	tr := trie.New()
+	tr.AddFile(filepath.Join("testdata", "ospd2.txt"))
+	orderers := []csp.ValueOrderer{&order.Ascending{}, order.NewRandom(1), &order.LeastConstraining{}, order.NewEnglishLetters()}
+	for _, tt := range dropquoteTests {
+		for _, o := range orderers {
+			dropquote := NewDropquotePuzzle(tt.input, tr)
+			if !dropquote.Solve(csp.Settings{Decider: &decide.Min{}, ValueOrderer: o}) {
+				t.Errorf("test: %s, orderer: %T, failed to solve!\n", tt.name, o)
+			}
+			if got := dropquote.String(); got != tt.want {
+				t.Errorf("test: %s, orderer: %T, got: %s, want: %s\n", tt.name, o, got, tt.want)
+			}
+		}
+	}
+}
+
+func BenchmarkDropquote(b *testing.B) {
+	tr := trie.New()
+	tr.AddFile(filepath.Join("testdata", "ospd2.txt"))
+	input := dropquoteTests[len(dropquoteTests)-1].input
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dropquote := NewDropquotePuzzle(input, tr)
+		if !dropquote.Solve(csp.Settings{Decider: &decide.First{}}) {
+			b.Fatal("failed to solve")
+		}
+	}
+}
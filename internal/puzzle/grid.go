@@ -1,6 +1,9 @@
 package puzzle
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/offpath/puzzleutils/internal/constraints"
 	"github.com/offpath/puzzleutils/internal/csp"
 )
@@ -17,15 +20,129 @@ type GridPuzzle struct {
 }
 
 func NewGridPuzzle(width int, height int, valueSet []string) *GridPuzzle {
-	return &GridPuzzle{NewPuzzle(width*height, valueSet), width, height}
+	p := &GridPuzzle{NewPuzzle(width*height, valueSet), width, height}
+	p.cellName = func(i int) string {
+		return GridEntry{i / width, i % width}.String()
+	}
+	return p
+}
+
+func (p *GridPuzzle) Clone() *GridPuzzle {
+	return &GridPuzzle{p.Puzzle.Clone(), p.width, p.height}
+}
+
+// UnmarshalJSON loads a state saved by MarshalJSON into a puzzle
+// already built with the same shape.
+func (p *GridPuzzle) UnmarshalJSON(data []byte) error {
+	if p.Puzzle == nil {
+		p.Puzzle = &Puzzle{}
+	}
+	return p.unmarshal(data, p.width*p.height)
+}
+
+// String names the entry as in "r3c5", counting rows and columns
+// from 1.
+func (e GridEntry) String() string {
+	return fmt.Sprintf("r%dc%d", e.Row+1, e.Col+1)
 }
 
+// AddGroup adds a group named after the cells it covers, for example
+// "row 3".
 func (p *GridPuzzle) AddGroup(group []GridEntry, constraint csp.ConstraintChecker) {
+	p.AddNamedGroup(gridGroupName(group), group, constraint)
+}
+
+func (p *GridPuzzle) AddNamedGroup(name string, group []GridEntry, constraint csp.ConstraintChecker) {
 	var flatGroup []int
 	for _, e := range group {
 		flatGroup = append(flatGroup, e.Row*p.width+e.Col)
 	}
-	p.Puzzle.problem.AddGroup(flatGroup, constraint)
+	p.Puzzle.problem.AddNamedGroup(name, flatGroup, constraint)
+}
+
+// AddSumGroup adds a group whose values' weights must add up to
+// target, and differ if isUnique is set; see constraints.Sum.
+func (p *GridPuzzle) AddSumGroup(group []GridEntry, target int, isUnique bool) {
+	p.AddGroup(group, constraints.Sum(target, p.Weights(), isUnique))
+}
+
+// AddLessThan requires a's value to weigh less than b's.
+func (p *GridPuzzle) AddLessThan(a, b GridEntry) {
+	p.AddNamedGroup(fmt.Sprintf("%s<%s", a, b), []GridEntry{a, b}, constraints.LessThan(p.Weights()))
+}
+
+// AddThermometer requires values to increase from the bulb at the
+// start of path.
+func (p *GridPuzzle) AddThermometer(path []GridEntry) {
+	p.AddNamedGroup("thermometer "+pathName(path), path, constraints.LessThan(p.Weights()))
+}
+
+// AddWhisper requires neighbours along path to differ by at least k.
+func (p *GridPuzzle) AddWhisper(path []GridEntry, k int) {
+	p.AddNamedGroup("whisper "+pathName(path), path, constraints.DifferAtLeast(k, p.Weights()))
+}
+
+// AddWhiteDot requires a and b to be consecutive, as with a white
+// Kropki dot.
+func (p *GridPuzzle) AddWhiteDot(a, b GridEntry) {
+	p.AddNamedGroup(fmt.Sprintf("white dot %s-%s", a, b), []GridEntry{a, b}, constraints.DifferBy(1, p.Weights()))
+}
+
+// AddBlackDot requires one of a and b to be double the other, as with
+// a black Kropki dot.
+func (p *GridPuzzle) AddBlackDot(a, b GridEntry) {
+	p.AddNamedGroup(fmt.Sprintf("black dot %s-%s", a, b), []GridEntry{a, b}, constraints.Ratio(2, p.Weights()))
+}
+
+// AddPattern requires the values along group, written as in the
+// value set, to match the regular expression expr; see
+// constraints.RegularExpression.
+func (p *GridPuzzle) AddPattern(group []GridEntry, expr string) error {
+	c, err := constraints.RegularExpression(expr, p.valueSet)
+	if err != nil {
+		return err
+	}
+	p.AddGroup(group, c)
+	return nil
+}
+
+// pathName names a path by its ends, as in "r1c1-r1c4".
+func pathName(path []GridEntry) string {
+	if len(path) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", path[0], path[len(path)-1])
+}
+
+func gridGroupName(group []GridEntry) string {
+	if len(group) == 0 {
+		return ""
+	}
+	sameRow, sameCol := true, true
+	for _, e := range group {
+		sameRow = sameRow && e.Row == group[0].Row
+		sameCol = sameCol && e.Col == group[0].Col
+	}
+	switch {
+	case sameRow && len(group) > 1:
+		return fmt.Sprintf("row %d", group[0].Row+1)
+	case sameCol && len(group) > 1:
+		return fmt.Sprintf("column %d", group[0].Col+1)
+	default:
+		return fmt.Sprintf("cells %s-%s", group[0], group[len(group)-1])
+	}
+}
+
+// NewTrace returns a trace that names decisions by their grid entry.
+func (p *GridPuzzle) NewTrace() *csp.Trace {
+	t := p.Puzzle.NewTrace()
+	t.DecisionNames = nil
+	for i := 0; i < p.height; i++ {
+		for j := 0; j < p.width; j++ {
+			t.DecisionNames = append(t.DecisionNames, GridEntry{i, j}.String())
+		}
+	}
+	return t
 }
 
 func (p *GridPuzzle) RectGroup(row, col, height, width int) []GridEntry {
@@ -79,12 +196,70 @@ func NewSudokuPuzzle() *GridPuzzle {
 	}
 	for i := 0; i < 3; i++ {
 		for j := 0; j < 3; j++ {
-			p.AddGroup(p.RectGroup(i*3, j*3, 3, 3), constraints.Unique(true))
+			p.AddNamedGroup(fmt.Sprintf("box %d", i*3+j+1), p.RectGroup(i*3, j*3, 3, 3), constraints.Unique(true))
 		}
 	}
 	return p
 }
 
+// A Cage is a set of cells in a killer sudoku whose values must
+// differ and add up to Sum.
+type Cage struct {
+	Sum   int
+	Cells []GridEntry
+}
+
+func NewKillerSudokuPuzzle(cages []Cage) *GridPuzzle {
+	p := NewSudokuPuzzle()
+	for i, c := range cages {
+		p.AddNamedGroup(fmt.Sprintf("cage %d", i+1), c.Cells, constraints.Sum(c.Sum, p.Weights(), true))
+	}
+	return p
+}
+
+// A KenKenCage is a set of cells whose values op must combine into
+// Result; see constraints.Cage.
+type KenKenCage struct {
+	Op     constraints.Op
+	Result int
+	Cells  []GridEntry
+}
+
+// NewKenKenPuzzle returns a size by size KenKen, with the numbers from
+// 1 to size once in each row and column.
+func NewKenKenPuzzle(size int, cages []KenKenCage) *GridPuzzle {
+	var valueSet []string
+	for i := 1; i <= size; i++ {
+		valueSet = append(valueSet, fmt.Sprint(i))
+	}
+	p := NewGridPuzzle(size, size, valueSet)
+	for _, g := range p.RowGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	for _, g := range p.ColumnGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	for i, c := range cages {
+		p.AddNamedGroup(fmt.Sprintf("cage %d", i+1), c.Cells, constraints.Cage(c.Op, c.Result, p.Weights()))
+	}
+	return p
+}
+
+func init() {
+	csp.RegisterConstraint("nonogram", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c nonogramParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		for _, n := range c.Lengths {
+			if n < 1 {
+				return nil, fmt.Errorf("puzzle: nonogram block of length %d", n)
+			}
+		}
+		return nonogramConstraint{c.Lengths}, nil
+	})
+}
+
 type nonogramConstraint struct {
 	lengths []int
 }
@@ -104,7 +279,9 @@ func (c nonogramConstraint) Apply(all, dirty []*csp.Decision) bool {
 		if sum > len(ds) {
 			return
 		}
-		if ds[0].Possible(0) {
+		// Leaving this square empty is only possible if the
+		// remaining blocks still fit after it.
+		if sum < len(ds) && ds[0].Possible(0) {
 			b.Push(0)
 			f(lengths, ds[1:])
 			b.Pop()
@@ -133,6 +310,18 @@ func (c nonogramConstraint) Apply(all, dirty []*csp.Decision) bool {
 	return true
 }
 
+func (c nonogramConstraint) Cost() int {
+	return csp.CostExpensive
+}
+
+type nonogramParams struct {
+	Lengths []int `json:"lengths"`
+}
+
+func (c nonogramConstraint) MarshalConstraint() (string, interface{}) {
+	return "nonogram", nonogramParams{c.lengths}
+}
+
 func NewNonogramPuzzle(rows, cols [][]int) *GridPuzzle {
 	p := NewGridPuzzle(len(cols), len(rows), []string{".", "X"})
 	for i, g := range p.RowGroups() {
@@ -1,14 +1,266 @@
 package puzzle
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/offpath/puzzleutils/internal/constraints"
 	"github.com/offpath/puzzleutils/internal/csp"
 	"github.com/offpath/puzzleutils/internal/decide"
 )
 
 // TODO(dneal): Sudoku unittests.
 
+const classicSudoku = "53..7...." +
+	"6..195..." +
+	".98....6." +
+	"8...6...3" +
+	"4..8.3..1" +
+	"7...2...6" +
+	".6....28." +
+	"...419..5" +
+	"....8..79"
+
+const hardSudoku = "........." +
+	".....3.85" +
+	"..1.2...." +
+	"...5.7..." +
+	"..4...1.." +
+	".9......." +
+	"5......73" +
+	"..2.1...." +
+	"....4...9"
+
+func TestSudokuPropagate(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(classicSudoku)
+	if undecided, ok := sudoku.Propagate(csp.Settings{}); !ok || len(undecided) != 0 {
+		t.Errorf("classic: got (%d undecided, %v), want (0, true)", len(undecided), ok)
+	}
+
+	sudoku = NewSudokuPuzzle()
+	sudoku.Init(hardSudoku)
+	undecided, ok := sudoku.Propagate(csp.Settings{})
+	if !ok || len(undecided) == 0 {
+		t.Errorf("hard: got (%d undecided, %v), want guessing to be needed", len(undecided), ok)
+	}
+	for _, d := range undecided {
+		if d.Value() >= 0 || d.Index() < 0 || d.Index() >= 81 {
+			t.Errorf("hard: bad undecided decision %d", d.Index())
+		}
+	}
+}
+
+func TestSudokuTrace(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(classicSudoku)
+	trace := sudoku.NewTrace()
+	if !sudoku.Solve(csp.Settings{Decider: &decide.Min{}, Trace: trace}) {
+		t.Fatalf("failed to solve")
+	}
+	got := trace.String()
+	for _, want := range []string{
+		"row 1 unique removed 5 from r1c3\n",
+		"column 1 unique removed 6 from r3c1\n",
+		"box 1 unique removed 9 from r1c3\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("trace is missing %q", want)
+		}
+	}
+}
+
+func BenchmarkSudoku(b *testing.B) {
+	for _, bb := range []struct {
+		name    string
+		decider csp.Decider
+	}{
+		{"First", &decide.First{}},
+		{"Min", &decide.Min{}},
+		{"MinMin", &decide.MinMin{}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			var stats csp.Stats
+			for i := 0; i < b.N; i++ {
+				sudoku := NewSudokuPuzzle()
+				sudoku.Init(hardSudoku)
+				if !sudoku.Solve(csp.Settings{Decider: bb.decider, Stats: &stats}) {
+					b.Fatal("failed to solve")
+				}
+			}
+			b.ReportMetric(float64(stats.Decisions), "decisions/op")
+			b.ReportMetric(float64(stats.Backtracks), "backtracks/op")
+		})
+	}
+}
+
+func TestSudokuRestarts(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(hardSudoku)
+	if !sudoku.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve")
+	}
+	want := sudoku.String()
+	for _, r := range []csp.RestartStrategy{csp.LubyRestarts(10), csp.GeometricRestarts(10, 2)} {
+		for seed := int64(0); seed < 3; seed++ {
+			sudoku = NewSudokuPuzzle()
+			sudoku.Init(hardSudoku)
+			if !sudoku.Solve(csp.Settings{Decider: &decide.Min{}, Restarts: r, Seed: seed}) {
+				t.Errorf("%T, seed %d: failed to solve", r, seed)
+			} else if got := sudoku.String(); got != want {
+				t.Errorf("%T, seed %d: got\n%s\nwant\n%s", r, seed, got, want)
+			}
+		}
+	}
+}
+
+func TestSudokuUnique(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(classicSudoku)
+	if got := sudoku.Count(csp.Settings{Decider: &decide.Min{}, MaxSolutions: 2}); got != 1 {
+		t.Errorf("got %d solutions, want 1", got)
+	}
+	// Dropping the first row's givens leaves more than one solution.
+	sudoku = NewSudokuPuzzle()
+	sudoku.Init("........." + classicSudoku[9:])
+	if got := sudoku.Count(csp.Settings{Decider: &decide.Min{}, MaxSolutions: 2}); got != 2 {
+		t.Errorf("got %d solutions, want 2", got)
+	}
+}
+
+func TestSudokuOptimize(t *testing.T) {
+	// Without the first row's givens there are two solutions, which
+	// differ in the top left cell.
+	start := "........." + classicSudoku[9:]
+	var values []string
+	for _, o := range []csp.Objective{csp.MinimizeSum([]int{0}), csp.MaximizeSum([]int{0})} {
+		sudoku := NewSudokuPuzzle()
+		sudoku.Init(start)
+		if !sudoku.Optimize(o, csp.Settings{Decider: &decide.Min{}}) {
+			t.Fatalf("%+v: failed to solve", o)
+		}
+		values = append(values, sudoku.String()[:1])
+	}
+	if values[0] >= values[1] {
+		t.Errorf("got top left %s when minimizing and %s when maximizing", values[0], values[1])
+	}
+}
+
+func TestSudokuCheckpoint(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(classicSudoku)
+	checkpoint := sudoku.Checkpoint()
+	// The top right cell of the solution is 2, so 1 fails.
+	sudoku.Init("........1")
+	if sudoku.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("solved with a wrong given")
+	}
+	sudoku.Rollback(checkpoint)
+	clone := sudoku.Clone()
+	if !sudoku.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve after rolling back")
+	}
+	if !clone.Solve(csp.Settings{Decider: &decide.Min{}}) || clone.String() != sudoku.String() {
+		t.Errorf("clone solved to\n%s\nwant\n%s", clone, sudoku)
+	}
+}
+
+func TestSudokuInitErrors(t *testing.T) {
+	for _, tt := range []struct {
+		test  string
+		start string
+		want  []string
+	}{
+		{"valid", classicSudoku, nil},
+		{"short", classicSudoku[:80], []string{"has 80 cells, want 81"}},
+		{"unknown symbol", "x" + classicSudoku[1:80] + "0", []string{`unknown symbol 'x' at r1c1`, `unknown symbol '0' at r9c9`}},
+		{"repeated in row", "5" + classicSudoku[1:7] + "5" + classicSudoku[8:], []string{"given 5 at r1c8 contradicts row 1"}},
+		{"repeated in box", classicSudoku[:10] + "5" + classicSudoku[11:], []string{"given 5 at r2c2 contradicts"}},
+	} {
+		sudoku := NewSudokuPuzzle()
+		err := sudoku.Init(tt.start)
+		if (err != nil) != (len(tt.want) > 0) {
+			t.Errorf("test: %s, got error %v", tt.test, err)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("test: %s, got error %q, want it to mention %q", tt.test, err, want)
+			}
+		}
+	}
+	// Givens are set despite the errors.
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init("x" + classicSudoku[1:80])
+	for _, i := range []int{1, 79} {
+		if got, want := sudoku.Problem().Get(i).Value(), int(classicSudoku[i]-'1'); got != want {
+			t.Errorf("cell %d: got %d, want %d", i, got, want)
+		}
+	}
+	sudoku = NewSudokuPuzzle()
+	sudoku.Init(classicSudoku[:7] + "5" + classicSudoku[8:])
+	if sudoku.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Errorf("solved despite a contradicting given")
+	}
+}
+
+func TestSudokuJSON(t *testing.T) {
+	sudoku := NewSudokuPuzzle()
+	sudoku.Init(hardSudoku)
+	sudoku.Propagate(csp.Settings{})
+	data, err := json.Marshal(sudoku)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	loaded := NewSudokuPuzzle()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got, want := loaded.String(), sudoku.String(); got != want {
+		t.Errorf("loaded\n%s\nwant\n%s", got, want)
+	}
+	if !sudoku.Solve(csp.Settings{Decider: &decide.Min{}}) || !loaded.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got, want := loaded.String(), sudoku.String(); got != want {
+		t.Errorf("loaded puzzle solved to\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestNonogramJSON(t *testing.T) {
+	nonogram := NewNonogramPuzzle([][]int{{3}, {1, 1}, {3}}, [][]int{{3}, {1, 1}, {3}})
+	data, err := json.Marshal(nonogram)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	// The constraints are loaded with the state.
+	loaded := NewGridPuzzle(3, 3, []string{".", "X"})
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !nonogram.Solve(csp.Settings{Decider: &decide.First{}}) || !loaded.Solve(csp.Settings{Decider: &decide.First{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got, want := loaded.String(), nonogram.String(); got != want {
+		t.Errorf("loaded puzzle solved to\n%s\nwant\n%s", got, want)
+	}
+
+	for _, tt := range []struct {
+		test   string
+		data   string
+		puzzle *GridPuzzle
+	}{
+		{"shape", string(data), NewGridPuzzle(2, 2, []string{".", "X"})},
+		{"value set", strings.Replace(string(data), `"valueSet":[".","X"]`, `"valueSet":["."]`, 1), NewGridPuzzle(3, 3, []string{".", "X"})},
+	} {
+		if err := json.Unmarshal([]byte(tt.data), tt.puzzle); err == nil {
+			t.Errorf("test: %s, loaded a mismatched puzzle", tt.test)
+		}
+	}
+}
+
 func TestNonogram(t *testing.T) {
 	rows := [][]int{
 		{8, 7, 5, 7},
@@ -92,3 +344,190 @@ func TestNonogram(t *testing.T) {
 		t.Errorf("got:\n%s\nwant:\n%s\n", got, want)
 	}
 }
+
+func TestNonogramRow(t *testing.T) {
+	// A block of one in two squares fills either square, but the row
+	// may not be left empty.
+	p := csp.NewProblem(2, 2)
+	p.AddGroup([]int{0, 1}, nonogramConstraint{[]int{1}})
+	if got := p.Count(csp.Settings{Decider: &decide.First{}}); got != 2 {
+		t.Errorf("got %d solutions, want 2", got)
+	}
+}
+
+func TestNonogramPropagate(t *testing.T) {
+	nonogram := NewNonogramPuzzle([][]int{{3}, {1, 1}, {3}}, [][]int{{3}, {1, 1}, {3}})
+	undecided, ok := nonogram.Propagate(csp.Settings{})
+	if !ok || len(undecided) != 0 {
+		t.Errorf("got (%d undecided, %v), want (0, true)", len(undecided), ok)
+	}
+	if got, want := nonogram.String(), "XXX\nX.X\nXXX\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, want)
+	}
+
+	// Logic alone cannot choose between the two diagonals.
+	nonogram = NewNonogramPuzzle([][]int{{1}, {1}}, [][]int{{1}, {1}})
+	undecided, ok = nonogram.Propagate(csp.Settings{})
+	if !ok || len(undecided) != 4 {
+		t.Errorf("got (%d undecided, %v), want (4, true)", len(undecided), ok)
+	}
+
+	nonogram = NewNonogramPuzzle([][]int{{2}, {2}}, [][]int{{1}, {1}})
+	if _, ok := nonogram.Propagate(csp.Settings{}); ok {
+		t.Errorf("contradictory clues propagated without conflict")
+	}
+}
+
+func TestNonogramCount(t *testing.T) {
+	// A 2x2 nonogram with a single cell in each row and column has
+	// two solutions, one on each diagonal.
+	nonogram := NewNonogramPuzzle([][]int{{1}, {1}}, [][]int{{1}, {1}})
+	if got := nonogram.Count(csp.Settings{Decider: &decide.First{}}); got != 2 {
+		t.Errorf("got %d solutions, want 2", got)
+	}
+	nonogram = NewNonogramPuzzle([][]int{{2}, {1}}, [][]int{{1}, {2}})
+	if got := nonogram.Count(csp.Settings{Decider: &decide.First{}, MaxSolutions: 2}); got != 1 {
+		t.Errorf("got %d solutions, want 1", got)
+	}
+}
+
+const classicSolution = "534678912" +
+	"672195348" +
+	"198342567" +
+	"859761423" +
+	"426853791" +
+	"713924856" +
+	"961537284" +
+	"287419635" +
+	"345286179"
+
+// brickCages cuts a solved sudoku into cages of two cells across each
+// row, laid like bricks, with a single cell at one end of each row.
+func brickCages(solution string) []Cage {
+	cage := func(es ...GridEntry) Cage {
+		c := Cage{Cells: es}
+		for _, e := range es {
+			c.Sum += int(solution[e.Row*9+e.Col] - '0')
+		}
+		return c
+	}
+	var cages []Cage
+	for i := 0; i < 9; i++ {
+		j := i % 2
+		if j == 1 {
+			cages = append(cages, cage(GridEntry{i, 0}))
+		}
+		for ; j < 8; j += 2 {
+			cages = append(cages, cage(GridEntry{i, j}, GridEntry{i, j + 1}))
+		}
+		if j == 8 {
+			cages = append(cages, cage(GridEntry{i, 8}))
+		}
+	}
+	return cages
+}
+
+func TestKillerSudoku(t *testing.T) {
+	killer := NewKillerSudokuPuzzle(brickCages(classicSolution))
+	if got := killer.Count(csp.Settings{Decider: &decide.Min{}}); got != 2 {
+		t.Errorf("got %d solutions, want 2", got)
+	}
+	// One given tells the two apart.
+	if err := killer.Init("......9" + strings.Repeat(".", 74)); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if got := killer.Count(csp.Settings{Decider: &decide.Min{}}); got != 1 {
+		t.Fatalf("got %d solutions, want 1", got)
+	}
+	if !killer.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got := strings.ReplaceAll(killer.String(), "\n", ""); got != classicSolution {
+		t.Errorf("got %s, want %s", got, classicSolution)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	p := NewGridPuzzle(2, 1, []string{"1", "X", "10"})
+	if got := fmt.Sprint(p.Weights()); got != "[1 1 10]" {
+		t.Errorf("got weights %s, want [1 1 10]", got)
+	}
+	// Either 1 or X goes with 10.
+	p.AddSumGroup(p.RowGroups()[0], 11, true)
+	if got := p.Count(csp.Settings{Decider: &decide.First{}}); got != 4 {
+		t.Errorf("got %d solutions, want 4", got)
+	}
+}
+
+func TestKenKen(t *testing.T) {
+	kenken := NewKenKenPuzzle(4, []KenKenCage{
+		{constraints.Add, 4, []GridEntry{{0, 0}, {1, 0}}},
+		{constraints.Multiply, 6, []GridEntry{{0, 1}, {0, 2}}},
+		{constraints.Divide, 2, []GridEntry{{0, 3}, {1, 3}}},
+		{constraints.Multiply, 12, []GridEntry{{1, 1}, {2, 1}}},
+		{constraints.Subtract, 1, []GridEntry{{1, 2}, {2, 2}}},
+		{constraints.Multiply, 8, []GridEntry{{2, 0}, {3, 0}, {3, 1}}},
+		{constraints.AnyOp, 3, []GridEntry{{2, 3}, {3, 3}}},
+		{constraints.Add, 4, []GridEntry{{3, 2}}},
+	})
+	if got := kenken.Count(csp.Settings{Decider: &decide.Min{}}); got != 1 {
+		t.Fatalf("got %d solutions, want 1", got)
+	}
+	if !kenken.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got, want := kenken.String(), "1234\n3412\n4321\n2143\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, want)
+	}
+}
+
+func TestRelations(t *testing.T) {
+	p := NewGridPuzzle(4, 4, []string{"1", "2", "3", "4"})
+	for _, g := range p.RowGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	for _, g := range p.ColumnGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	p.AddThermometer([]GridEntry{{0, 0}, {0, 1}, {0, 2}, {0, 3}})
+	p.AddWhiteDot(GridEntry{1, 2}, GridEntry{1, 3})
+	p.AddBlackDot(GridEntry{2, 2}, GridEntry{2, 3})
+	p.AddLessThan(GridEntry{3, 1}, GridEntry{3, 0})
+	p.AddWhisper([]GridEntry{{2, 0}, {3, 0}}, 2)
+	p.AddLessThan(GridEntry{1, 0}, GridEntry{2, 0})
+	p.AddLessThan(GridEntry{1, 2}, GridEntry{1, 3})
+	if got := p.Count(csp.Settings{Decider: &decide.Min{}}); got != 1 {
+		t.Fatalf("got %d solutions, want 1", got)
+	}
+	if !p.Solve(csp.Settings{Decider: &decide.Min{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got, want := p.String(), "1234\n3412\n4321\n2143\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, want)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	// The nonogram from TestNonogramPropagate, with its clues as
+	// patterns.
+	p := NewGridPuzzle(3, 3, []string{".", "X"})
+	for i, g := range append(p.RowGroups(), p.ColumnGroups()...) {
+		expr := `\.*XXX\.*`
+		if i%3 == 1 {
+			expr = `\.*X\.+X\.*`
+		}
+		if err := p.AddPattern(g, expr); err != nil {
+			t.Fatalf("add pattern: %v", err)
+		}
+	}
+	undecided, ok := p.Propagate(csp.Settings{})
+	if !ok || len(undecided) != 0 {
+		t.Errorf("got (%d undecided, %v), want (0, true)", len(undecided), ok)
+	}
+	if got, want := p.String(), "XXX\nX.X\nXXX\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\n", got, want)
+	}
+	if err := p.AddPattern(p.RowGroups()[0], "X("); err == nil {
+		t.Errorf("bad pattern added without error")
+	}
+}
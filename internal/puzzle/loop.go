@@ -1,13 +1,49 @@
 package puzzle
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/offpath/puzzleutils/internal/csp"
 )
 
+func init() {
+	csp.RegisterConstraint("slitherlinkPoint", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		return slitherlinkPointConstraint{}, nil
+	})
+	csp.RegisterConstraint("slitherlinkBox", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c slitherlinkBoxParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		if c.N < 0 || c.N > 4 {
+			return nil, fmt.Errorf("puzzle: slitherlink box of %d lines", c.N)
+		}
+		return slitherlinkBoxConstraint{c.N}, nil
+	})
+	csp.RegisterConstraint("slitherlinkLoop", func(params json.RawMessage) (csp.ConstraintChecker, error) {
+		var c slitherlinkLoopParams
+		if err := json.Unmarshal(params, &c); err != nil {
+			return nil, err
+		}
+		if c.Rows < 1 || c.Cols < 1 || c.Rows*c.Cols > maxSlitherlinkBoxes {
+			return nil, errors.New("puzzle: bad slitherlink size")
+		}
+		return slitherlinkLoopConstraint{newSlitherlinkGrid(c.Rows, c.Cols)}, nil
+	})
+}
+
+// maxSlitherlinkBoxes bounds the size of a loaded slitherlink.
+const maxSlitherlinkBoxes = 1 << 16
+
 type slitherlinkPointConstraint struct{}
 
+func (c slitherlinkPointConstraint) MarshalConstraint() (string, interface{}) {
+	return "slitherlinkPoint", nil
+}
+
 func (c slitherlinkPointConstraint) Init(all []*csp.Decision, size int) {}
 func (c slitherlinkPointConstraint) Apply(all, dirty []*csp.Decision) bool {
 	// Either 0 or 2 lines may connect to a point (no crossing lines)
@@ -40,6 +76,14 @@ func (c slitherlinkPointConstraint) Apply(all, dirty []*csp.Decision) bool {
 
 type slitherlinkBoxConstraint struct{ n int }
 
+type slitherlinkBoxParams struct {
+	N int `json:"n"`
+}
+
+func (c slitherlinkBoxConstraint) MarshalConstraint() (string, interface{}) {
+	return "slitherlinkBox", slitherlinkBoxParams{c.n}
+}
+
 func (c slitherlinkBoxConstraint) Init(all []*csp.Decision, size int) {
 	// Optimize a bit by special-casing 0.
 	if c.n == 0 {
@@ -84,6 +128,9 @@ type slitherlinkLoopConstraint struct{ g SlitherlinkPuzzle }
 
 func (c slitherlinkLoopConstraint) Init(all []*csp.Decision, size int) {}
 func (c slitherlinkLoopConstraint) Apply(all, dirty []*csp.Decision) bool {
+	if len(all) != c.g.numLines() {
+		return false
+	}
 	// Exactly one loop is allowed
 	hasLoop := false
 	hasUnfinishedLoop := false
@@ -123,6 +170,21 @@ func (c slitherlinkLoopConstraint) Apply(all, dirty []*csp.Decision) bool {
 	return !(hasLoop && hasUnfinishedLoop)
 }
 
+// Cost marks the loop check, which walks the whole grid, to run after
+// the local point and box constraints.
+func (c slitherlinkLoopConstraint) Cost() int {
+	return csp.CostExpensive
+}
+
+type slitherlinkLoopParams struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+func (c slitherlinkLoopConstraint) MarshalConstraint() (string, interface{}) {
+	return "slitherlinkLoop", slitherlinkLoopParams{c.g.numRows, c.g.numCols}
+}
+
 type slitherlinkPoint struct {
 	row, col int
 }
@@ -133,6 +195,19 @@ type SlitherlinkPuzzle struct {
 	lineToPointsMap  map[int][]slitherlinkPoint
 }
 
+func (g *SlitherlinkPuzzle) Clone() *SlitherlinkPuzzle {
+	return &SlitherlinkPuzzle{g.Puzzle.Clone(), g.numRows, g.numCols, g.lineToPointsMap}
+}
+
+// UnmarshalJSON loads a state saved by MarshalJSON into a puzzle
+// already built with the same shape.
+func (g *SlitherlinkPuzzle) UnmarshalJSON(data []byte) error {
+	if g.Puzzle == nil {
+		g.Puzzle = &Puzzle{}
+	}
+	return g.unmarshal(data, g.numLines())
+}
+
 func (g SlitherlinkPuzzle) numLines() int {
 	return g.numCols*(g.numRows+1) + g.numRows*(g.numCols+1)
 }
@@ -169,11 +244,12 @@ func (g SlitherlinkPuzzle) verticalLine(pt slitherlinkPoint) int {
 
 }
 
-func NewSlitherlinkPuzzle(input string) SlitherlinkPuzzle {
-	lines := strings.Split(input, "\n")
+// newSlitherlinkGrid returns the lines of a grid of rows by cols
+// boxes, without a Puzzle.
+func newSlitherlinkGrid(rows, cols int) SlitherlinkPuzzle {
 	g := SlitherlinkPuzzle{
-		numRows:         len(lines),
-		numCols:         len(lines[0]),
+		numRows:         rows,
+		numCols:         cols,
 		lineToPointsMap: map[int][]slitherlinkPoint{},
 	}
 	for i := 0; i <= g.numRows; i++ {
@@ -184,6 +260,12 @@ func NewSlitherlinkPuzzle(input string) SlitherlinkPuzzle {
 			}
 		}
 	}
+	return g
+}
+
+func NewSlitherlinkPuzzle(input string) SlitherlinkPuzzle {
+	lines := strings.Split(input, "\n")
+	g := newSlitherlinkGrid(len(lines), len(lines[0]))
 	g.Puzzle = NewPuzzle(g.numLines(), []string{"0", "1"})
 	for i := 0; i <= g.numRows; i++ {
 		for j := 0; j <= g.numCols; j++ {
@@ -1,6 +1,7 @@
 package puzzle
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/offpath/puzzleutils/internal/csp"
@@ -60,3 +61,74 @@ func TestSlitherlink(t *testing.T) {
 		}
 	}
 }
+
+func TestSlitherlinkJSON(t *testing.T) {
+	tt := slitherlinkTests[len(slitherlinkTests)-1]
+	data, err := json.Marshal(NewSlitherlinkPuzzle(tt.input))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	loaded := NewSlitherlinkPuzzle(tt.input)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !loaded.Solve(csp.Settings{Decider: &decide.First{}}) {
+		t.Fatalf("failed to solve")
+	}
+	if got := loaded.String(); got != tt.want {
+		t.Errorf("got: \n%s\n want: \n%s\n", got, tt.want)
+	}
+	small := NewSlitherlinkPuzzle("4")
+	if err := json.Unmarshal(data, &small); err == nil {
+		t.Errorf("loaded into a puzzle of another size")
+	}
+}
+
+func TestSlitherlinkProbe(t *testing.T) {
+	tt := slitherlinkTests[len(slitherlinkTests)-1]
+	slitherlink := NewSlitherlinkPuzzle(tt.input)
+	plain, _ := slitherlink.Propagate(csp.Settings{})
+	probed, ok := slitherlink.Propagate(csp.Settings{Probe: true})
+	if !ok || len(probed) >= len(plain) {
+		t.Errorf("probing left %d of %d lines undecided", len(probed), len(plain))
+	}
+	if !slitherlink.Solve(csp.Settings{Decider: &decide.First{}, Probe: true}) {
+		t.Fatalf("failed to solve")
+	}
+	if got := slitherlink.String(); got != tt.want {
+		t.Errorf("got: \n%s\n want: \n%s\n", got, tt.want)
+	}
+}
+
+func TestSlitherlinkBackjump(t *testing.T) {
+	for _, tt := range slitherlinkTests {
+		slitherlink := NewSlitherlinkPuzzle(tt.input)
+		if !slitherlink.Solve(csp.Settings{Decider: &decide.First{}, Backjump: true, LearnNogoods: true}) {
+			t.Errorf("test: %s, failed to solve!\n", tt.name)
+		}
+		if got := slitherlink.String(); got != tt.want {
+			t.Errorf("test: %s, got: \n%s\n want: \n%s\n", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSlitherlinkParallel(t *testing.T) {
+	tt := slitherlinkTests[len(slitherlinkTests)-1]
+	slitherlink := NewSlitherlinkPuzzle(tt.input)
+	if !slitherlink.Solve(csp.Settings{Decider: &decide.First{}, Workers: 4}) {
+		t.Fatalf("failed to solve")
+	}
+	if got := slitherlink.String(); got != tt.want {
+		t.Errorf("got: \n%s\n want: \n%s\n", got, tt.want)
+	}
+}
+
+func BenchmarkSlitherlink(b *testing.B) {
+	input := slitherlinkTests[len(slitherlinkTests)-1].input
+	for i := 0; i < b.N; i++ {
+		slitherlink := NewSlitherlinkPuzzle(input)
+		if !slitherlink.Solve(csp.Settings{Decider: &decide.First{}}) {
+			b.Fatal("failed to solve")
+		}
+	}
+}
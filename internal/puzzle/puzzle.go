@@ -1,6 +1,14 @@
 package puzzle
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/offpath/puzzleutils/internal/csp"
 )
 
@@ -118,10 +126,71 @@ func NewSudoku(p *Puzzle2) *Grid {
 type Puzzle struct {
 	problem  *csp.Problem
 	valueSet []string
+	// cellName, if set, names decisions in errors.
+	cellName func(i int) string
 }
 
 func NewPuzzle(size int, valueSet []string) *Puzzle {
-	return &Puzzle{csp.NewProblem(size, len(valueSet)), valueSet}
+	return &Puzzle{csp.NewProblem(size, len(valueSet)), valueSet, nil}
+}
+
+// Problem returns the underlying csp.Problem, for tools such as the
+// grader that work on any puzzle.
+func (p *Puzzle) Problem() *csp.Problem {
+	return p.problem
+}
+
+// Clone returns a copy of the puzzle in its current state, so that
+// it can be solved separately; see csp.Problem.Clone.
+func (p *Puzzle) Clone() *Puzzle {
+	return &Puzzle{p.problem.Clone(), p.valueSet, p.cellName}
+}
+
+// Checkpoint saves the puzzle's state for Rollback, so that givens
+// can be tried and taken back.
+func (p *Puzzle) Checkpoint() int {
+	return p.problem.Checkpoint()
+}
+
+func (p *Puzzle) Rollback(checkpoint int) error {
+	return p.problem.Rollback(checkpoint)
+}
+
+type puzzleJSON struct {
+	ValueSet []string     `json:"valueSet"`
+	Problem  *csp.Problem `json:"problem"`
+}
+
+// MarshalJSON saves the puzzle's current state; see
+// csp.Problem.MarshalJSON.
+func (p *Puzzle) MarshalJSON() ([]byte, error) {
+	return json.Marshal(puzzleJSON{p.valueSet, p.problem})
+}
+
+// UnmarshalJSON loads a state saved by MarshalJSON. Loading into a
+// GridPuzzle keeps its shape, which must match the saved puzzle.
+func (p *Puzzle) UnmarshalJSON(data []byte) error {
+	return p.unmarshal(data, -1)
+}
+
+// unmarshal loads a saved state, which must have size decisions unless
+// size is negative. p is left as it was if the state cannot be loaded.
+func (p *Puzzle) unmarshal(data []byte, size int) error {
+	var pj puzzleJSON
+	if err := json.Unmarshal(data, &pj); err != nil {
+		return err
+	}
+	if pj.Problem == nil {
+		return errors.New("puzzle: no problem in saved puzzle")
+	}
+	if len(pj.ValueSet) != pj.Problem.ValueSize() {
+		return fmt.Errorf("puzzle: saved puzzle has %d values, want %d", len(pj.ValueSet), pj.Problem.ValueSize())
+	}
+	if n := pj.Problem.Size(); size >= 0 && n != size {
+		return fmt.Errorf("puzzle: saved puzzle has %d cells, want %d", n, size)
+	}
+	p.valueSet, p.problem = pj.ValueSet, pj.Problem
+	return nil
 }
 
 func (p *Puzzle) AllGroup() []int {
@@ -136,6 +205,21 @@ func (p *Puzzle) ValueSet() []string {
 	return p.valueSet
 }
 
+// Weights returns a weight for each value, for arithmetic constraints
+// such as constraints.Sum: the number the value names, or its index if
+// it does not name one.
+func (p *Puzzle) Weights() []int {
+	var result []int
+	for i, v := range p.valueSet {
+		w, err := strconv.Atoi(v)
+		if err != nil {
+			w = i
+		}
+		result = append(result, w)
+	}
+	return result
+}
+
 func (p *Puzzle) InvertSet() map[string]int {
 	invertSet := map[string]int{}
 	for i, v := range p.valueSet {
@@ -144,19 +228,112 @@ func (p *Puzzle) InvertSet() map[string]int {
 	return invertSet
 }
 
-func (p *Puzzle) Init(start string) {
+// NewTrace returns a trace that names values using the value set,
+// for use in csp.Settings.
+func (p *Puzzle) NewTrace() *csp.Trace {
+	return &csp.Trace{ValueNames: p.valueSet}
+}
+
+// Init sets the givens in start, one character per decision, taking
+// any character not in the value set as an empty cell. Every given is
+// set, even if start has the wrong length; an error reports the
+// length, characters other than the blanks ". _?", and givens that
+// contradict the constraints.
+func (p *Puzzle) Init(start string) error {
 	invertSet := p.InvertSet()
-	for i, c := range start {
-		if v, ok := invertSet[string(c)]; ok {
-			p.problem.Set(i, v)
+	var errs []error
+	if n := utf8.RuneCountInString(start); n != p.problem.Size() {
+		errs = append(errs, fmt.Errorf("puzzle: init string has %d cells, want %d", n, p.problem.Size()))
+	}
+	// before is kept to find the givens at fault, should the givens
+	// turn out to contradict the constraints.
+	before := p.problem.Clone()
+	type given struct{ i, v int }
+	var givens []given
+	i := 0
+	for _, c := range start {
+		if v, ok := invertSet[string(c)]; !ok {
+			if !strings.ContainsRune(blanks, c) {
+				errs = append(errs, fmt.Errorf("puzzle: unknown symbol %q at %s", c, p.cell(i)))
+			}
+		} else if i < p.problem.Size() {
+			if err := p.problem.Set(i, v); err != nil {
+				errs = append(errs, p.givenError(err))
+			} else {
+				givens = append(givens, given{i, v})
+			}
 		}
+		i++
 	}
+	// Propagating once finds whether any given contradicts the
+	// constraints. Only then is each given checked in turn.
+	if _, ok := p.problem.Clone().Propagate(csp.Settings{}); !ok {
+		found := false
+		for _, g := range givens {
+			if err := before.SetChecked(g.i, g.v); err != nil {
+				errs = append(errs, p.givenError(err))
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, errors.New("puzzle: the givens contradict the constraints"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// blanks are the characters that Init expects for an empty cell.
+const blanks = ". _?"
+
+func (p *Puzzle) cell(i int) string {
+	if p.cellName != nil {
+		return p.cellName(i)
+	}
+	return fmt.Sprintf("cell %d", i)
+}
+
+// givenError describes an error from csp.Problem.Set in the puzzle's
+// terms.
+func (p *Puzzle) givenError(err error) error {
+	var conflict *csp.ConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+	given := fmt.Sprintf("given %s at %s", p.valueSet[conflict.Value], p.cell(conflict.Decision))
+	if conflict.Group == nil {
+		return fmt.Errorf("puzzle: %s is already ruled out", given)
+	}
+	name := conflict.Group.Name()
+	if name == "" {
+		name = "a group"
+	}
+	return fmt.Errorf("puzzle: %s contradicts %s", given, name)
 }
 
 func (p *Puzzle) Solve(s csp.Settings) bool {
 	return p.problem.Solve(s)
 }
 
+func (p *Puzzle) SolveContext(ctx context.Context, s csp.Settings) (bool, error) {
+	return p.problem.SolveContext(ctx, s)
+}
+
+// Propagate fills in as much of the puzzle as the constraints allow
+// without guessing; see csp.Problem.Propagate.
+func (p *Puzzle) Propagate(s csp.Settings) ([]*csp.Decision, bool) {
+	return p.problem.Propagate(s)
+}
+
+// Optimize fills in the solution with the lowest score under o; see
+// csp.Problem.Optimize.
+func (p *Puzzle) Optimize(o csp.Objective, s csp.Settings) bool {
+	return p.problem.Optimize(o, s)
+}
+
+func (p *Puzzle) Count(s csp.Settings) int {
+	return p.problem.Count(s)
+}
+
 func (p *Puzzle) String() string {
 	result := ""
 	for i := 0; i < p.problem.Size(); i++ {
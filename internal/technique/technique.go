@@ -0,0 +1,249 @@
+// Package technique solves puzzles built from uniqueness constraints,
+// such as sudoku and its variants, step by step using the techniques
+// a person would, and reports which technique each step used.
+package technique
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/offpath/puzzleutils/internal/constraints"
+	"github.com/offpath/puzzleutils/internal/csp"
+	"github.com/offpath/puzzleutils/internal/puzzle"
+)
+
+// A Candidate is a value that may still go in a cell.
+type Candidate struct {
+	Decision, Value int
+}
+
+// A Step is a single deduction: the technique used, the values it
+// placed or the candidates it eliminated, and a description such as
+// "5 can only go in r3c4 in row 3".
+type Step struct {
+	Technique    string
+	Placements   []Candidate
+	Eliminations []Candidate
+	Description  string
+}
+
+func (s Step) String() string {
+	return s.Technique + ": " + s.Description
+}
+
+// A house is a group of cells that cannot repeat a value, such as a
+// row, column or box. Values below len(cells) must appear in a
+// covering house.
+type house struct {
+	name     string
+	cells    []int
+	covering bool
+	// Houses of the same family never share a cell, as with the
+	// rows of a sudoku.
+	family int
+}
+
+func (h *house) mustContain(v int) bool {
+	return h.covering && v < len(h.cells)
+}
+
+// A Solver applies techniques to a puzzle.
+type Solver struct {
+	p      *csp.Problem
+	houses []*house
+	// cellHouses holds the houses containing each cell.
+	cellHouses [][]*house
+	names      []string
+	values     []string
+	// placed is set for givens and for cells filled in by a step.
+	placed []bool
+}
+
+// A technique returns the step it finds, or nil.
+type technique func(s *Solver) *Step
+
+// techniques are tried in order, simplest first.
+var techniques = []technique{
+	nakedSingle,
+	hiddenSingle,
+	nakedPair,
+	hiddenPair,
+	fish(1),
+	fish(2),
+	fish(3),
+	xyWing,
+	coloring,
+}
+
+// New returns a Solver for g, which works on its puzzle in place.
+// Only the groups with Unique constraints are used to find steps,
+// but every constraint is propagated after each step.
+func New(g *puzzle.GridPuzzle) (*Solver, error) {
+	t := g.NewTrace()
+	p := g.Problem()
+	s := &Solver{
+		p:          p,
+		cellHouses: make([][]*house, p.Size()),
+		names:      t.DecisionNames,
+		values:     t.ValueNames,
+		placed:     make([]bool, p.Size()),
+	}
+	for i, grp := range p.Groups() {
+		unique, covering := constraints.IsUnique(grp.Constraint())
+		if !unique {
+			continue
+		}
+		h := &house{name: grp.Name(), covering: covering}
+		if h.name == "" {
+			h.name = fmt.Sprintf("group %d", i)
+		}
+		for _, d := range grp.Decisions() {
+			h.cells = append(h.cells, d.Index())
+		}
+		s.addHouse(h)
+	}
+	for i := range s.placed {
+		s.placed[i] = p.Get(i).Value() >= 0
+	}
+	if _, ok := p.Propagate(csp.Settings{}); !ok {
+		return nil, errors.New("technique: the puzzle has no solution")
+	}
+	return s, nil
+}
+
+// addHouse adds h to the first family whose houses it does not
+// overlap.
+func (s *Solver) addHouse(h *house) {
+	for h.family = 0; ; h.family++ {
+		overlaps := false
+		for _, c := range h.cells {
+			for _, h2 := range s.cellHouses[c] {
+				overlaps = overlaps || h2.family == h.family
+			}
+		}
+		if !overlaps {
+			break
+		}
+	}
+	s.houses = append(s.houses, h)
+	for _, c := range h.cells {
+		s.cellHouses[c] = append(s.cellHouses[c], h)
+	}
+}
+
+// Step applies the simplest technique that makes progress and
+// returns what it did, or nil if the puzzle is solved or no technique
+// applies. It returns an error if the step shows the puzzle has no
+// solution.
+func (s *Solver) Step() (*Step, error) {
+	for _, t := range techniques {
+		step := t(s)
+		if step == nil {
+			continue
+		}
+		for _, c := range step.Placements {
+			s.p.Get(c.Decision).RestrictTo(c.Value)
+			s.placed[c.Decision] = true
+		}
+		for _, c := range step.Eliminations {
+			s.p.Get(c.Decision).Restrict(c.Value)
+		}
+		if _, ok := s.p.Propagate(csp.Settings{}); !ok {
+			return step, errors.New("technique: the puzzle has no solution")
+		}
+		return step, nil
+	}
+	return nil, nil
+}
+
+// Solve takes steps until no technique applies, and returns them.
+func (s *Solver) Solve() ([]*Step, error) {
+	var steps []*Step
+	for {
+		step, err := s.Step()
+		if step == nil || err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+	}
+}
+
+// Solved reports whether every cell has been placed.
+func (s *Solver) Solved() bool {
+	for _, placed := range s.placed {
+		if !placed {
+			return false
+		}
+	}
+	return true
+}
+
+// open reports whether cell i could still take value v and has not
+// been placed.
+func (s *Solver) open(i, v int) bool {
+	d := s.p.Get(i)
+	return d.Count() > 1 && d.Possible(v)
+}
+
+// candidates returns the cells of h that are open for v.
+func (s *Solver) candidates(h *house, v int) []int {
+	var result []int
+	for _, c := range h.cells {
+		if s.open(c, v) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// sees reports whether cells a and b share a house.
+func (s *Solver) sees(a, b int) bool {
+	for _, h := range s.cellHouses[a] {
+		for _, h2 := range s.cellHouses[b] {
+			if h == h2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// valuesOf returns the candidates of cell i.
+func (s *Solver) valuesOf(i int) []int {
+	var result []int
+	for v := 0; v < s.p.ValueSize(); v++ {
+		if s.p.Get(i).Possible(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func (s *Solver) cellList(cells []int) string {
+	var names []string
+	for _, c := range cells {
+		names = append(names, s.names[c])
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *Solver) houseList(houses []*house) string {
+	var names []string
+	for _, h := range houses {
+		names = append(names, h.name)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// eliminated lists the cells in a set of eliminations.
+func (s *Solver) eliminated(es []Candidate) string {
+	var cells []int
+	for _, e := range es {
+		cells = append(cells, e.Decision)
+	}
+	return s.cellList(cells)
+}
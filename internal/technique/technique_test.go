@@ -0,0 +1,213 @@
+package technique
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/offpath/puzzleutils/internal/constraints"
+	"github.com/offpath/puzzleutils/internal/csp"
+	"github.com/offpath/puzzleutils/internal/decide"
+	"github.com/offpath/puzzleutils/internal/puzzle"
+)
+
+func sudoku(givens string) *puzzle.GridPuzzle {
+	p := puzzle.NewSudokuPuzzle()
+	p.Init(givens)
+	return p
+}
+
+// sudoku6 builds a 6x6 sudoku with 2x3 boxes.
+func sudoku6(givens string) *puzzle.GridPuzzle {
+	p := puzzle.NewGridPuzzle(6, 6, []string{"1", "2", "3", "4", "5", "6"})
+	for _, g := range p.RowGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	for _, g := range p.ColumnGroups() {
+		p.AddGroup(g, constraints.Unique(true))
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 2; j++ {
+			p.AddNamedGroup(fmt.Sprintf("box %d", i*2+j+1), p.RectGroup(i*2, j*3, 2, 3), constraints.Unique(true))
+		}
+	}
+	p.Init(givens)
+	return p
+}
+
+var solveTests = []struct {
+	test   string
+	puzzle *puzzle.GridPuzzle
+	solved bool
+}{
+	{"classic", sudoku("53..7...." +
+		"6..195..." +
+		".98....6." +
+		"8...6...3" +
+		"4..8.3..1" +
+		"7...2...6" +
+		".6....28." +
+		"...419..5" +
+		"....8..79"), true},
+	{"medium", sudoku("..9748..." +
+		"7........" +
+		".2.1.9..." +
+		"..7...24." +
+		".64.1.59." +
+		".98...3.." +
+		"...8.3.2." +
+		"........6" +
+		"...2759.."), true},
+	{"hard", sudoku("1....7.9." +
+		".3..2...8" +
+		"..96..5.." +
+		"..53..9.." +
+		".1..8...2" +
+		"6....4..." +
+		"3......1." +
+		".4......7" +
+		"..7...3.."), false},
+	{"brutal", sudoku("........." +
+		".....3.85" +
+		"..1.2...." +
+		"...5.7..." +
+		"..4...1.." +
+		".9......." +
+		"5......73" +
+		"..2.1...." +
+		"....4...9"), true},
+	{"x-wing", sudoku("1.....569" +
+		"492.561.8" +
+		".561.924." +
+		"..964.8.1" +
+		".64.1...." +
+		"218.356.4" +
+		".4.5...16" +
+		"9.5.614.2" +
+		"621.....5"), true},
+	{"xy-wing", sudoku("9..24...." +
+		".5.69.231" +
+		".2..5..9." +
+		".9.7..32." +
+		"..29356.7" +
+		".7...29.." +
+		".69.2..73" +
+		"51..79.62" +
+		"2.7.86..9"), true},
+	{"6x6", sudoku6("....5." +
+		"...1.3" +
+		"..4..." +
+		"5...34" +
+		"3..61." +
+		".....5"), true},
+}
+
+func TestSolve(t *testing.T) {
+	for _, tt := range solveTests {
+		solution := tt.puzzle.Clone()
+		if solution.Count(csp.Settings{Decider: &decide.Min{}}) != 1 {
+			t.Fatalf("test: %s, puzzle has no unique solution", tt.test)
+		}
+		solution.Solve(csp.Settings{Decider: &decide.Min{}})
+		want := solution.Problem()
+
+		s, err := New(tt.puzzle)
+		if err != nil {
+			t.Fatalf("test: %s, %v", tt.test, err)
+		}
+		steps, err := s.Solve()
+		if err != nil {
+			t.Errorf("test: %s, %v", tt.test, err)
+		}
+		used := map[string]int{}
+		for _, step := range steps {
+			used[step.Technique]++
+			// Every step must agree with the solution.
+			for _, c := range step.Placements {
+				if want.Get(c.Decision).Value() != c.Value {
+					t.Errorf("test: %s, %v placed a wrong value", tt.test, step)
+				}
+			}
+			for _, c := range step.Eliminations {
+				if want.Get(c.Decision).Value() == c.Value {
+					t.Errorf("test: %s, %v removed the solution", tt.test, step)
+				}
+			}
+		}
+		if s.Solved() != tt.solved {
+			t.Errorf("test: %s, got solved %v after %d steps %v, want %v", tt.test, s.Solved(), len(steps), used, tt.solved)
+		}
+	}
+}
+
+// restrict removes v from every cell of an empty sudoku not listed in
+// keep, for the given rows (from 0) or columns (from 9).
+func restrict(g *puzzle.GridPuzzle, v int, lines []int, keep ...int) {
+	kept := map[int]bool{}
+	for _, i := range keep {
+		kept[i] = true
+	}
+	for _, line := range lines {
+		for j := 0; j < 9; j++ {
+			i := line*9 + j
+			if line >= 9 {
+				i = j*9 + line - 9
+			}
+			if !kept[i] {
+				g.Problem().Get(i).Restrict(v)
+			}
+		}
+	}
+}
+
+func TestTechniques(t *testing.T) {
+	for _, tt := range []struct {
+		test      string
+		technique technique
+		setup     func(g *puzzle.GridPuzzle)
+		want      []Candidate
+	}{
+		{"pointing", fish(1), func(g *puzzle.GridPuzzle) {
+			// 1 in box 1 is confined to row 1.
+			for _, i := range []int{9, 10, 11, 18, 19, 20} {
+				g.Problem().Get(i).Restrict(0)
+			}
+		}, []Candidate{{3, 0}, {4, 0}, {5, 0}, {6, 0}, {7, 0}, {8, 0}}},
+		{"x-wing", fish(2), func(g *puzzle.GridPuzzle) {
+			// 1 in rows 1 and 5 is confined to columns 2 and 7.
+			restrict(g, 0, []int{0, 4}, 1, 6, 37, 42)
+		}, []Candidate{
+			{10, 0}, {19, 0}, {28, 0}, {46, 0}, {55, 0}, {64, 0}, {73, 0},
+			{15, 0}, {24, 0}, {33, 0}, {51, 0}, {60, 0}, {69, 0}, {78, 0},
+		}},
+		{"xy-wing", xyWing, func(g *puzzle.GridPuzzle) {
+			// r1c1 holds 1 or 2, r1c5 1 or 3 and r5c1 2 or 3, so r5c5
+			// cannot be 3.
+			g.Problem().Get(0).RestrictToSet(map[int]bool{0: true, 1: true})
+			g.Problem().Get(4).RestrictToSet(map[int]bool{0: true, 2: true})
+			g.Problem().Get(36).RestrictToSet(map[int]bool{1: true, 2: true})
+		}, []Candidate{{40, 2}}},
+		{"coloring", coloring, func(g *puzzle.GridPuzzle) {
+			// 1 in row 1 is r1c1 or r1c5, in column 5 r1c5 or r5c5,
+			// and in row 5 r5c5 or r5c2. Either r1c1 or r5c2 is 1, so
+			// the cells that see both cannot be.
+			restrict(g, 0, []int{0}, 0, 4)
+			restrict(g, 0, []int{13}, 4, 40)
+			restrict(g, 0, []int{4}, 40, 37)
+		}, []Candidate{{10, 0}, {19, 0}, {27, 0}, {45, 0}}},
+	} {
+		g := puzzle.NewSudokuPuzzle()
+		tt.setup(g)
+		s, err := New(g)
+		if err != nil {
+			t.Fatalf("test: %s, %v", tt.test, err)
+		}
+		step := tt.technique(s)
+		if step == nil {
+			t.Errorf("test: %s, found no step", tt.test)
+			continue
+		}
+		if fmt.Sprint(step.Eliminations) != fmt.Sprint(tt.want) {
+			t.Errorf("test: %s, got %v, want %v", tt.test, step.Eliminations, tt.want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package technique
+
+import "fmt"
+
+// nakedSingle places a cell with only one candidate left.
+func nakedSingle(s *Solver) *Step {
+	for i, placed := range s.placed {
+		if d := s.p.Get(i); !placed && d.Count() == 1 {
+			return &Step{
+				Technique:   "naked single",
+				Placements:  []Candidate{{i, d.Value()}},
+				Description: fmt.Sprintf("%s can only be %s", s.names[i], s.values[d.Value()]),
+			}
+		}
+	}
+	return nil
+}
+
+// hiddenSingle places a value that has only one cell left in a house
+// that must contain it.
+func hiddenSingle(s *Solver) *Step {
+	for _, h := range s.houses {
+		for v := 0; v < s.p.ValueSize(); v++ {
+			if !h.mustContain(v) {
+				continue
+			}
+			if cells := s.candidates(h, v); len(cells) == 1 {
+				return &Step{
+					Technique:   "hidden single",
+					Placements:  []Candidate{{cells[0], v}},
+					Description: fmt.Sprintf("%s can only go in %s in %s", s.values[v], s.names[cells[0]], h.name),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// nakedPair finds two cells of a house with the same two candidates,
+// which must take those values between them.
+func nakedPair(s *Solver) *Step {
+	for _, h := range s.houses {
+		for i, a := range h.cells {
+			if s.p.Get(a).Count() != 2 {
+				continue
+			}
+			values := s.valuesOf(a)
+			for _, b := range h.cells[i+1:] {
+				if s.p.Get(b).Count() != 2 || !s.p.Get(b).Possible(values[0]) || !s.p.Get(b).Possible(values[1]) {
+					continue
+				}
+				var es []Candidate
+				for _, c := range h.cells {
+					for _, v := range values {
+						if c != a && c != b && s.open(c, v) {
+							es = append(es, Candidate{c, v})
+						}
+					}
+				}
+				if len(es) > 0 {
+					return &Step{
+						Technique:    "naked pair",
+						Eliminations: es,
+						Description: fmt.Sprintf("%s and %s hold %s and %s in %s, removing them from %s",
+							s.names[a], s.names[b], s.values[values[0]], s.values[values[1]], h.name, s.eliminated(es)),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hiddenPair finds two values that have the same two cells left in a
+// house that must contain them, so those cells can hold nothing else.
+func hiddenPair(s *Solver) *Step {
+	for _, h := range s.houses {
+		for a := 0; a < s.p.ValueSize(); a++ {
+			cells := s.candidates(h, a)
+			if !h.mustContain(a) || len(cells) != 2 {
+				continue
+			}
+			for b := a + 1; b < s.p.ValueSize(); b++ {
+				if other := s.candidates(h, b); !h.mustContain(b) || len(other) != 2 || other[0] != cells[0] || other[1] != cells[1] {
+					continue
+				}
+				var es []Candidate
+				for _, c := range cells {
+					for _, v := range s.valuesOf(c) {
+						if v != a && v != b {
+							es = append(es, Candidate{c, v})
+						}
+					}
+				}
+				if len(es) > 0 {
+					return &Step{
+						Technique:    "hidden pair",
+						Eliminations: es,
+						Description: fmt.Sprintf("%s and %s can only go in %s and %s in %s, removing the other candidates",
+							s.values[a], s.values[b], s.names[cells[0]], s.names[cells[1]], h.name),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+var fishNames = []string{1: "pointing", 2: "x-wing", 3: "swordfish"}
+
+// fish returns the technique that finds n disjoint houses, all of
+// which must contain a value, whose cells left for it lie within n
+// houses of another family. Each of those n houses then holds one of
+// the n placements, so the value can be removed from their other
+// cells. With n = 1 this covers both pointing and claiming.
+func fish(n int) technique {
+	return func(s *Solver) *Step {
+		families := 0
+		for _, h := range s.houses {
+			if h.family >= families {
+				families = h.family + 1
+			}
+		}
+		for v := 0; v < s.p.ValueSize(); v++ {
+			for base := 0; base < families; base++ {
+				for cover := 0; cover < families; cover++ {
+					if cover == base {
+						continue
+					}
+					var bases []*house
+					for _, h := range s.houses {
+						if h.family != base || !h.mustContain(v) {
+							continue
+						}
+						if covers := s.covers(v, []*house{h}, cover); len(covers) > 0 && len(covers) <= n {
+							bases = append(bases, h)
+						}
+					}
+					var step *Step
+					combinations(len(bases), n, func(chosen []int) bool {
+						var hs []*house
+						for _, i := range chosen {
+							hs = append(hs, bases[i])
+						}
+						step = s.fishStep(n, v, hs, cover)
+						return step == nil
+					})
+					if step != nil {
+						return step
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// covers returns the houses of a family that hold the cells left for
+// v in the given houses, or nil if some cell is in none of them.
+func (s *Solver) covers(v int, hs []*house, family int) []*house {
+	var result []*house
+	for _, h := range hs {
+		for _, c := range s.candidates(h, v) {
+			var cover *house
+			for _, h2 := range s.cellHouses[c] {
+				if h2.family == family {
+					cover = h2
+				}
+			}
+			if cover == nil {
+				return nil
+			}
+			found := false
+			for _, h2 := range result {
+				found = found || h2 == cover
+			}
+			if !found {
+				result = append(result, cover)
+			}
+		}
+	}
+	return result
+}
+
+func (s *Solver) fishStep(n, v int, bases []*house, family int) *Step {
+	covers := s.covers(v, bases, family)
+	if len(covers) != n {
+		return nil
+	}
+	inBase := map[int]bool{}
+	for _, h := range bases {
+		for _, c := range s.candidates(h, v) {
+			inBase[c] = true
+		}
+	}
+	var es []Candidate
+	for _, h := range covers {
+		for _, c := range s.candidates(h, v) {
+			if !inBase[c] {
+				es = append(es, Candidate{c, v})
+			}
+		}
+	}
+	if len(es) == 0 {
+		return nil
+	}
+	return &Step{
+		Technique:    fishNames[n],
+		Eliminations: es,
+		Description: fmt.Sprintf("%s in %s is confined to %s, removing it from %s",
+			s.values[v], s.houseList(bases), s.houseList(covers), s.eliminated(es)),
+	}
+}
+
+// combinations calls f with each k-element subset of 0..n-1 in turn,
+// stopping when f returns false.
+func combinations(n, k int, f func([]int) bool) {
+	chosen := make([]int, 0, k)
+	var rec func(start int) bool
+	rec = func(start int) bool {
+		if len(chosen) == k {
+			return f(chosen)
+		}
+		for i := start; i <= n-(k-len(chosen)); i++ {
+			chosen = append(chosen, i)
+			if !rec(i + 1) {
+				return false
+			}
+			chosen = chosen[:len(chosen)-1]
+		}
+		return true
+	}
+	rec(0)
+}
+
+// xyWing finds a pivot cell with candidates x and y that sees a cell
+// with x and z and another with y and z. Whichever value the pivot
+// takes, one of the two wings must be z, so z can be removed from
+// every cell that sees both wings.
+func xyWing(s *Solver) *Step {
+	var bivalue []int
+	for i := 0; i < s.p.Size(); i++ {
+		if s.p.Get(i).Count() == 2 {
+			bivalue = append(bivalue, i)
+		}
+	}
+	for _, pivot := range bivalue {
+		xy := s.valuesOf(pivot)
+		for _, a := range bivalue {
+			if a == pivot || !s.sees(pivot, a) {
+				continue
+			}
+			// a shares exactly one value with the pivot, and its other
+			// value is z.
+			av := s.valuesOf(a)
+			var y, z int
+			switch {
+			case av[0] == xy[0] && av[1] != xy[1]:
+				y, z = xy[1], av[1]
+			case av[1] == xy[0] && av[0] != xy[1]:
+				y, z = xy[1], av[0]
+			case av[0] == xy[1] && av[1] != xy[0]:
+				y, z = xy[0], av[1]
+			case av[1] == xy[1] && av[0] != xy[0]:
+				y, z = xy[0], av[0]
+			default:
+				continue
+			}
+			for _, b := range bivalue {
+				d := s.p.Get(b)
+				if b == pivot || b == a || !s.sees(pivot, b) || !d.Possible(y) || !d.Possible(z) {
+					continue
+				}
+				var es []Candidate
+				for c := 0; c < s.p.Size(); c++ {
+					if c != a && c != b && s.open(c, z) && s.sees(c, a) && s.sees(c, b) {
+						es = append(es, Candidate{c, z})
+					}
+				}
+				if len(es) > 0 {
+					return &Step{
+						Technique:    "xy-wing",
+						Eliminations: es,
+						Description: fmt.Sprintf("%s with wings %s and %s removes %s from %s",
+							s.names[pivot], s.names[a], s.names[b], s.values[z], s.eliminated(es)),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// coloring follows chains of conjugate pairs, the only two cells left
+// for a value in a house that must contain it, giving the cells of
+// each pair opposite colors. Exactly one color holds the value
+// throughout a chain. A color that appears twice in one house must be
+// the false one, and a cell that sees both colors cannot hold the
+// value.
+func coloring(s *Solver) *Step {
+	for v := 0; v < s.p.ValueSize(); v++ {
+		links := map[int][]int{}
+		for _, h := range s.houses {
+			if cells := s.candidates(h, v); h.mustContain(v) && len(cells) == 2 {
+				links[cells[0]] = append(links[cells[0]], cells[1])
+				links[cells[1]] = append(links[cells[1]], cells[0])
+			}
+		}
+		color := map[int]int{}
+		for i := 0; i < s.p.Size(); i++ {
+			if _, ok := color[i]; ok || len(links[i]) == 0 {
+				continue
+			}
+			// Color the chain through i.
+			var chain [2][]int
+			color[i] = 0
+			queue := []int{i}
+			for len(queue) > 0 {
+				c := queue[0]
+				queue = queue[1:]
+				chain[color[c]] = append(chain[color[c]], c)
+				for _, c2 := range links[c] {
+					if _, ok := color[c2]; !ok {
+						color[c2] = 1 - color[c]
+						queue = append(queue, c2)
+					}
+				}
+			}
+			if step := s.colorStep(v, chain); step != nil {
+				return step
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Solver) colorStep(v int, chain [2][]int) *Step {
+	for _, cells := range chain {
+		for i, a := range cells {
+			for _, b := range cells[i+1:] {
+				if !s.sees(a, b) {
+					continue
+				}
+				var es []Candidate
+				for _, c := range cells {
+					es = append(es, Candidate{c, v})
+				}
+				return &Step{
+					Technique:    "coloring",
+					Eliminations: es,
+					Description: fmt.Sprintf("%s in %s and %s would repeat, so it is removed from %s",
+						s.values[v], s.names[a], s.names[b], s.eliminated(es)),
+				}
+			}
+		}
+	}
+	inChain := map[int]bool{}
+	for _, cells := range chain {
+		for _, c := range cells {
+			inChain[c] = true
+		}
+	}
+	seesAny := func(c int, cells []int) bool {
+		for _, c2 := range cells {
+			if s.sees(c, c2) {
+				return true
+			}
+		}
+		return false
+	}
+	var es []Candidate
+	for c := 0; c < s.p.Size(); c++ {
+		if !inChain[c] && s.open(c, v) && seesAny(c, chain[0]) && seesAny(c, chain[1]) {
+			es = append(es, Candidate{c, v})
+		}
+	}
+	if len(es) == 0 {
+		return nil
+	}
+	return &Step{
+		Technique:    "coloring",
+		Eliminations: es,
+		Description: fmt.Sprintf("%s must be in one of %s or one of %s, removing it from %s",
+			s.values[v], s.cellList(chain[0]), s.cellList(chain[1]), s.eliminated(es)),
+	}
+}